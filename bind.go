@@ -3,7 +3,10 @@ package complang
 import (
 	"context"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 )
 
 func BindValue(v any) Value {
@@ -12,15 +15,33 @@ func BindValue(v any) Value {
 		return NullValue{}
 	case Value:
 		return v
+	case error:
+		return Error{ErrorMessage: v.Error(), Code: GoErrorCode, Err: v}
 	case reflect.Value:
 		return BindValue(v.Interface())
 	case string:
 		return StringValue{v}
 	case bool:
 		return BoolValue{v}
+	case *big.Int:
+		return NumValue{new(big.Rat).SetInt(v)}
+	case *big.Rat:
+		return NumValue{new(big.Rat).Set(v)}
+	case *big.Float:
+		if v.IsInf() {
+			return Error{ErrorMessage: fmt.Sprintf("Cannot bind %v to complang", v), Code: BindCode}
+		}
+		r, _ := v.Rat(nil)
+		return NumValue{r}
 	default:
 		vv := reflect.ValueOf(v)
 		switch {
+		case vv.CanInt():
+			return NumValue{new(big.Rat).SetInt64(vv.Int())}
+		case vv.CanUint():
+			return NumValue{new(big.Rat).SetInt(new(big.Int).SetUint64(vv.Uint()))}
+		case vv.CanFloat():
+			return bindFloat(vv.Float(), vv.Type().Bits())
 		case vv.Kind() == reflect.Slice || vv.Kind() == reflect.Array:
 			vs := []Value{}
 			for i := 0; i < vv.Len(); i++ {
@@ -61,22 +82,40 @@ func BindValue(v any) Value {
 			}
 			return MapValue(m)
 		default:
-			return Error{fmt.Sprintf(
-				"Cannot bind value of type %T to complang yet: %#V", v, v)}
+			return Error{
+				ErrorMessage: fmt.Sprintf("Cannot bind value of type %T to complang yet: %#v", v, v),
+				Code:         BindCode,
+			}
 		}
 	}
 }
 
+// bindFloat converts floats via their shortest decimal representation, so that 0.1 binds as
+// exactly 1/10 rather than the nearest binary fraction.
+func bindFloat(f float64, bits int) Value {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return Error{ErrorMessage: fmt.Sprintf("Cannot bind %v to complang", f), Code: BindCode}
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, bits))
+	return NumValue{r}
+}
+
 func UnbindValue(ctx context.Context, v Value) (any, error) {
 	switch v := v.(type) {
 	case StringValue:
 		return v.Text, nil
+	case BoolValue:
+		return v.Bool, nil
+	case NumValue:
+		return new(big.Rat).Set(v.Num), nil
 	default:
 		return nil, fmt.Errorf("Cannot bind complang value back to Go yet: %s",
 			Show(ctx, v))
 	}
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func bindMethod(vv reflect.Value, me reflect.Method) Value {
 	mh := vv.MethodByName(me.Name)
 	params := []string{}
@@ -88,24 +127,81 @@ func bindMethod(vv reflect.Value, me reflect.Method) Value {
 		for _, p := range params {
 			pv, ok := env.Lookup(p)
 			if !ok {
-				return Error{fmt.Sprintf("Unbound %v", p)}
+				return Error{ErrorMessage: fmt.Sprintf("Unbound %v", p), Code: UnboundRefCode}
 			}
-			x, err := UnbindValue(ctx, pv)
+			x, err := unbindArg(ctx, pv, me.Type.In(len(args)+1))
 			if err != nil {
-				return BindValue(err)
+				return Error{ErrorMessage: err.Error(), Code: BindCode, Err: err}
 			}
-			args = append(args, reflect.ValueOf(x))
+			args = append(args, x)
 		}
 		ret := mh.Call(args)
+		if n := len(ret); n > 0 && me.Type.Out(n-1) == errorType {
+			if err, _ := ret[n-1].Interface().(error); err != nil {
+				return BindValue(err)
+			}
+			ret = ret[0 : n-1]
+		}
 		if len(ret) == 1 {
 			return BindValue(ret[0])
 		} else {
 			return NullValue{}
 		}
 	}
+	effect := WriteEffect
+	if me.Type.NumIn() == 1 {
+		effect = ReadEffect
+	}
+	if d, ok := vv.Interface().(EffectDeclarer); ok {
+		if e := d.ComplangEffect(me.Name); e != UndeclaredEffect {
+			effect = e
+		}
+	}
 	return Closure{
 		Params: params,
 		Call:   c,
-		IsPure: me.Type.NumIn() == 1,
+		IsPure: me.Type.NumIn() == 1 && effect == ReadEffect,
+		Name:   fmt.Sprintf("%s.%s", vv.Type(), me.Name),
+		Effect: effect,
+	}
+}
+
+// unbindArg converts a complang value to an argument of type t. Numbers are converted to the numeric
+// type of the parameter if they fit exactly.
+func unbindArg(ctx context.Context, v Value, t reflect.Type) (reflect.Value, error) {
+	x, err := UnbindValue(ctx, v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if r, ok := x.(*big.Rat); ok {
+		return unbindNum(r, t)
+	}
+	xv := reflect.ValueOf(x)
+	if !xv.Type().AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("Cannot pass %s as %v", Show(ctx, v), t)
+	}
+	return xv, nil
+}
+
+func unbindNum(r *big.Rat, t reflect.Type) (reflect.Value, error) {
+	z := reflect.New(t).Elem()
+	switch {
+	case z.CanInt():
+		if r.IsInt() && r.Num().IsInt64() && !z.OverflowInt(r.Num().Int64()) {
+			z.SetInt(r.Num().Int64())
+			return z, nil
+		}
+	case z.CanUint():
+		if r.IsInt() && r.Num().IsUint64() && !z.OverflowUint(r.Num().Uint64()) {
+			z.SetUint(r.Num().Uint64())
+			return z, nil
+		}
+	case z.CanFloat():
+		f, _ := r.Float64()
+		z.SetFloat(f)
+		return z, nil
+	case reflect.TypeOf(r).AssignableTo(t):
+		return reflect.ValueOf(r), nil
 	}
+	return reflect.Value{}, fmt.Errorf("Cannot pass %s as %v", FormatNum(r), t)
 }
@@ -0,0 +1,106 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+)
+
+// Messages understood by BoolValue. Branches and the right operands of and/or are usually lambda
+// blocks, which are only evaluated when needed:
+//
+//	$x not
+//	$x and [$y]
+//	$x or [$y]
+//	$x then [$a] else [$b]
+var boolMessages = []string{"!=", "==", "and", "not", "or", "then"}
+
+func (x BoolValue) respond(ctx context.Context, message string) (Value, bool) {
+	switch message {
+	case "not":
+		return BoolValue{!x.Bool}, true
+	case "==", "!=":
+		return equality(message, func(ctx context.Context, arg Value) bool {
+			y, ok := arg.(BoolValue)
+			return ok && x.Bool == y.Bool
+		}), true
+	case "and":
+		return builtin("and", "y", func(ctx context.Context, arg Value) Value {
+			if !x.Bool {
+				return x
+			}
+			return ToBool(ctx, arg)
+		}), true
+	case "or":
+		return builtin("or", "y", func(ctx context.Context, arg Value) Value {
+			if x.Bool {
+				return x
+			}
+			return ToBool(ctx, arg)
+		}), true
+	case "then":
+		return builtin("then", "branch", func(ctx context.Context, arg Value) Value {
+			if x.Bool {
+				return conditional{taken: true, value: arg}
+			}
+			return conditional{}
+		}), true
+	default:
+		return nil, false
+	}
+}
+
+// ToBool forces a value and checks that it is a BoolValue, returning a type error otherwise.
+func ToBool(ctx context.Context, v Value) Value {
+	v = Force(ctx, v)
+	if _, ok := v.(BoolValue); ok || IsError(v) {
+		return v
+	}
+	return Error{
+		ErrorMessage: fmt.Sprintf("expected a bool, got %s", describeValue(ctx, v)),
+		Code:         TypeErrorCode,
+	}
+}
+
+// conditional is the result of `$x then [$a]`. It behaves like the value of the branch if it was
+// taken, or null otherwise, and responds to else by evaluating the alternative if it was not.
+// Branches are returned as tail values, so that recursion through them does not grow the stack.
+type conditional struct {
+	taken bool
+	value Value
+}
+
+func (c conditional) Message(ctx context.Context, msg Value) Value {
+	if s, ok := msg.(StringValue); ok && s.Text == "else" {
+		return builtin("else", "branch", func(ctx context.Context, arg Value) Value {
+			if c.taken {
+				return c.result()
+			}
+			return Tail(arg)
+		})
+	}
+	return c.result().Message(ctx, msg)
+}
+
+func (c conditional) result() Value {
+	if c.taken {
+		return Tail(c.value)
+	}
+	return NullValue{}
+}
+
+// Force evaluates deferred values that are free of side-effects: lazy values, tail values,
+// conditionals, pure closures and zero-parameter transparent closures such as lambda blocks.
+// Side-effects of the result are not run. Transparent closures are called in a loop, so that calls in
+// tail position do not grow the stack.
+func Force(ctx context.Context, v Value) Value {
+	for {
+		if next, ok := step(ctx, v); ok {
+			v = next
+			continue
+		}
+		if c, ok := v.(Closure); ok && len(c.Params) == 0 && c.IsPure {
+			return Run(ctx, c)
+		}
+		return v
+	}
+}
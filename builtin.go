@@ -0,0 +1,56 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Builtin is a function of fixed arity implemented in Go and free of side-effects, such as the
+// operators of numbers and the functions of the standard library. Unlike Closure it accepts errors as
+// arguments, and it is called as soon as all the arguments are supplied.
+type Builtin struct {
+	Name   string
+	Params []string
+
+	// Args collects the arguments applied so far, in order.
+	Args []Value
+
+	Call func(ctx context.Context, args []Value) Value
+}
+
+var _ Function = Builtin{}
+
+// Arity is the number of arguments still to be supplied.
+func (b Builtin) Arity() int {
+	return len(b.Params) - len(b.Args)
+}
+
+func (b Builtin) Message(ctx context.Context, msg Value) Value {
+	switch msg.(type) {
+	case ShowMessage:
+		return StringValue{fmt.Sprintf("<%s:%s>", b.Name, strings.Join(b.Params[len(b.Args):], ","))}
+	case RunMessage:
+		return b
+	case CompleteRequest, PlanRequest:
+		return NullValue{}
+	default:
+		applied := b
+		applied.Args = append(b.Args[:len(b.Args):len(b.Args)], msg)
+		if len(applied.Args) == len(b.Params) {
+			return b.Call(ctx, applied.Args)
+		}
+		return applied
+	}
+}
+
+// builtin wraps a Go function of one argument, named param, as a Builtin.
+func builtin(name, param string, f func(context.Context, Value) Value) Value {
+	return Builtin{
+		Name:   name,
+		Params: []string{param},
+		Call: func(ctx context.Context, args []Value) Value {
+			return f(ctx, args[0])
+		},
+	}
+}
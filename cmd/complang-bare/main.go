@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 
 	cl "github.com/t0yv0/complang"
 	"github.com/t0yv0/complang/repl"
@@ -11,8 +12,9 @@ import (
 
 func main() {
 	ctx := context.Background()
-	err := repl.ReadEvalPrintLoop(ctx, repl.ReadEvalPrintLoopOptions{
+	err := repl.Main(ctx, repl.ReadEvalPrintLoopOptions{
 		HistoryFile: "/tmp/complang-bare-readline.history",
+		SessionFile: "/tmp/complang-bare-session.json",
 		InitialEnvironment: map[string]cl.Value{
 			"$digits": cl.MapValue(map[string]cl.Value{
 				"one":   cl.StringValue{Text: "1"},
@@ -30,7 +32,7 @@ func main() {
 				},
 			}),
 		},
-	})
+	}, os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,115 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+)
+
+// Effect classifies the side-effects a value performs when responding to :run.
+type Effect int
+
+const (
+	// UndeclaredEffect is the zero value; undeclared effects are conservatively treated as writes.
+	UndeclaredEffect Effect = iota
+	// ReadEffect observes state without modifying it.
+	ReadEffect
+	// WriteEffect modifies state.
+	WriteEffect
+	// DestructiveEffect modifies state in a way that is hard to undo, such as deleting data.
+	DestructiveEffect
+)
+
+// Normalize resolves UndeclaredEffect to WriteEffect.
+func (e Effect) Normalize() Effect {
+	if e == UndeclaredEffect {
+		return WriteEffect
+	}
+	return e
+}
+
+func (e Effect) String() string {
+	switch e {
+	case UndeclaredEffect:
+		return "undeclared"
+	case ReadEffect:
+		return "read"
+	case WriteEffect:
+		return "write"
+	case DestructiveEffect:
+		return "destructive"
+	default:
+		return fmt.Sprintf("Effect(%d)", int(e))
+	}
+}
+
+// EffectDeclarer can be implemented by Go types bound with BindValue to declare the effects of their
+// methods. Methods that are not declared default to ReadEffect when they take no arguments and to
+// WriteEffect otherwise. Methods without arguments are called eagerly, like pure closures, only if
+// their effect is ReadEffect.
+type EffectDeclarer interface {
+	ComplangEffect(method string) Effect
+}
+
+// StrongestEffect finds the most severe effect in a plan, or returns ReadEffect for an empty plan.
+func StrongestEffect(steps []PlanStep) Effect {
+	e := ReadEffect
+	for _, s := range steps {
+		if s.Effect.Normalize() > e {
+			e = s.Effect.Normalize()
+		}
+	}
+	return e
+}
+
+type effectLimitKey struct{}
+
+// WithEffectLimit returns a context in which running closures with effects stronger than limit fails
+// with an error instead of performing them. Transparent closures are exempt, and so are pure closures
+// unless they declare effects stronger than ReadEffect.
+func WithEffectLimit(ctx context.Context, limit Effect) context.Context {
+	return context.WithValue(ctx, effectLimitKey{}, limit)
+}
+
+// exempt reports whether running c needs neither an effect limit nor observation: transparent closures
+// only run other closures, and pure closures that declare no effect stronger than a read have none.
+func (c Closure) exempt() bool {
+	return c.Transparent || c.IsPure && c.Effect <= ReadEffect
+}
+
+func checkEffectLimit(ctx context.Context, c Closure) Value {
+	if c.exempt() {
+		return nil
+	}
+	limit, ok := ctx.Value(effectLimitKey{}).(Effect)
+	if !ok || c.Effect.Normalize() <= limit {
+		return nil
+	}
+	return Error{
+		ErrorMessage: fmt.Sprintf("refusing to run %s: %s effects are not allowed",
+			c.Name, c.Effect.Normalize()),
+		Code: EffectRefusedCode,
+	}
+}
+
+type runObserverKey struct{}
+
+// RunObserver is notified after a closure implemented in Go performs side-effects, with the
+// closure, including its Name, Path and Args, and the result of its Call. It is also notified of
+// closures refused by WithEffectLimit, with the refusal as the result.
+type RunObserver func(ctx context.Context, c Closure, result Value)
+
+// WithRunObserver returns a context in which observer is notified every time running a closure
+// makes it perform side-effects. Transparent closures are not reported, and neither are pure closures
+// unless they declare effects stronger than ReadEffect.
+func WithRunObserver(ctx context.Context, observer RunObserver) context.Context {
+	return context.WithValue(ctx, runObserverKey{}, observer)
+}
+
+func observeRun(ctx context.Context, c Closure, result Value) {
+	if c.exempt() {
+		return
+	}
+	if observer, ok := ctx.Value(runObserverKey{}).(RunObserver); ok {
+		observer(ctx, c, result)
+	}
+}
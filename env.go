@@ -54,6 +54,12 @@ func (me mapEnv) Lookup(s string) (Value, bool) {
 	return v, ok
 }
 
+// ExtendEnv returns an environment that binds symbol to value and otherwise looks symbols up in env.
+// The binding shadows any binding of the same symbol in env, which is left unchanged.
+func ExtendEnv(env Env, symbol string, value Value) Env {
+	return &extendedEnv{Env: env, symbol: symbol, value: value}
+}
+
 type extendedEnv struct {
 	Env
 	symbol string
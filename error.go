@@ -0,0 +1,203 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Codes classify errors for programmatic handling.
+const (
+	DoesNotUnderstandCode = "does-not-understand"
+	UnboundRefCode        = "unbound-ref"
+	IndexOutOfRangeCode   = "index-out-of-range"
+	TypeErrorCode         = "type-error"
+	DivisionByZeroCode    = "division-by-zero"
+	// ArityCode marks functions applied to more arguments than they take.
+	ArityCode = "arity"
+	// MaxDepthCode marks calls nested deeper than the limit set with WithMaxDepth.
+	MaxDepthCode = "max-depth"
+	// CancelledCode marks evaluations stopped because their context was cancelled.
+	CancelledCode = "cancelled"
+	// BindCode marks values that could not be converted between Go and complang.
+	BindCode = "bind"
+	// GoErrorCode marks errors returned by Go code.
+	GoErrorCode       = "go-error"
+	EffectRefusedCode = "effect-refused"
+)
+
+// Error values float out of expressions: they respond to every message with themselves.
+//
+// Error also implements the Go error interface and unwraps to Err, so that embedders can inspect
+// failures with errors.Is and errors.As.
+type Error struct {
+	ErrorMessage string
+
+	// Code is a machine-readable classification of the error such as DoesNotUnderstandCode.
+	Code string
+
+	// Err is the Go error this error wraps, if any.
+	Err error
+
+	// Span locates the expression that failed in the source code, when known.
+	Span *Span
+
+	// Trace lists the message sends that led to the failure, starting from the outermost receiver.
+	Trace []Send
+}
+
+var _ error = Error{}
+
+// Span is a character-based range in the source code.
+type Span struct {
+	Offset int
+	Length int
+
+	// Source is the text that Offset and Length refer to, when known. Spans of errors raised inside
+	// functions defined by earlier statements refer to the source of those statements.
+	Source string
+}
+
+// Send describes a message sent to a receiver, both rendered as source code.
+type Send struct {
+	Receiver string
+	Message  string
+}
+
+func (x Error) Message(_ context.Context, v Value) Value {
+	switch v.(type) {
+	case ShowMessage:
+		return StringValue{fmt.Sprintf("ERROR: %s", x.ErrorMessage)}
+	case RunMessage:
+		return x
+	default:
+		// Errors are self-evaluating to float out of expressions.
+		return x
+	}
+}
+
+func (x Error) Error() string {
+	return x.ErrorMessage
+}
+
+func (x Error) Unwrap() error {
+	return x.Err
+}
+
+// IsError checks if a value is an error, including errors produced by DoNotUnderstandError.
+func IsError(v Value) bool {
+	_, ok := AsError(v)
+	return ok
+}
+
+// AsError converts error values, including errors produced by DoNotUnderstandError, to Error.
+func AsError(v Value) (Error, bool) {
+	switch v := v.(type) {
+	case Error:
+		return v, true
+	case *Error:
+		return *v, true
+	case *doesNotUnderstandError:
+		return v.error(context.Background()), true
+	default:
+		return Error{}, false
+	}
+}
+
+// AnnotateError records where an error was first produced. Errors that already carry a source span
+// are returned unchanged, as are values that are not errors.
+func AnnotateError(v Value, span Span, trace []Send) Value {
+	switch e := v.(type) {
+	case Error:
+		if e.Span == nil {
+			e.Span = &span
+			e.Trace = trace
+		}
+		return e
+	case *Error:
+		return AnnotateError(*e, span, trace)
+	case *doesNotUnderstandError:
+		if e.span == nil {
+			return &doesNotUnderstandError{obj: e.obj, message: e.message, span: &span, trace: trace}
+		}
+		return e
+	default:
+		return v
+	}
+}
+
+// ArityError reports a function applied to got arguments, more than it takes.
+func ArityError(ctx context.Context, f Function, got int) Value {
+	noun := "arguments"
+	if f.Arity() == 1 {
+		noun = "argument"
+	}
+	return Error{
+		ErrorMessage: fmt.Sprintf("%s takes %d %s, got %d", Show(ctx, f), f.Arity(), noun, got),
+		Code:         ArityCode,
+	}
+}
+
+func DoNotUnderstandError(ctx context.Context, obj Value, message Value) Value {
+	return &doesNotUnderstandError{obj: obj, message: message}
+}
+
+// doesNotUnderstandError defers formatting the message until it is needed, since objects
+// frequently try messages that they do not understand, for example in OverloadedValue.
+type doesNotUnderstandError struct {
+	obj     Value
+	message Value
+	span    *Span
+	trace   []Send
+	err     *Error
+}
+
+var _ Value = (*doesNotUnderstandError)(nil)
+
+func (dne *doesNotUnderstandError) Message(ctx context.Context, msg Value) Value {
+	return dne.error(ctx).Message(ctx, msg)
+}
+
+func (dne *doesNotUnderstandError) error(ctx context.Context) Error {
+	if dne.err == nil {
+		dne.err = &Error{
+			ErrorMessage: fmt.Sprintf("object %s does not understand %s",
+				describeValue(ctx, dne.obj),
+				describeValue(ctx, dne.message)),
+			Code: DoesNotUnderstandCode,
+		}
+	}
+	e := *dne.err
+	e.Span = dne.span
+	e.Trace = dne.trace
+	return e
+}
+
+// describeValue renders a value on a single line for use in error messages.
+func describeValue(ctx context.Context, v Value) string {
+	const maxKeys = 5
+	const maxWidth = 60
+	switch v := v.(type) {
+	case MapValue:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > maxKeys {
+			keys = append(keys[0:maxKeys], "...")
+		}
+		return fmt.Sprintf("map{%s}", strings.Join(keys, ", "))
+	case SliceValue:
+		return fmt.Sprintf("slice[%d]", len(v))
+	}
+	s := Show(ctx, v)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[0:i] + "..."
+	}
+	if r := []rune(s); len(r) > maxWidth {
+		s = string(r[0:maxWidth]) + "..."
+	}
+	return s
+}
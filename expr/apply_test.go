@@ -0,0 +1,162 @@
+package expr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cl "github.com/t0yv0/complang"
+)
+
+// counter is a Go closure of the given parameters that counts its calls and returns a map.
+func counter(calls *int, params ...string) cl.Closure {
+	return cl.Closure{
+		Params: params,
+		Call: func(context.Context, cl.Env) cl.Value {
+			*calls++
+			return cl.MapValue{"a": cl.StringValue{Text: "1"}}
+		},
+		Name: "counter",
+	}
+}
+
+func TestApplyPartially(t *testing.T) {
+	ctx := context.Background()
+	// [$x $y | $y]
+	f := EvalExpr(ctx, cl.NewMutableEnv(), &LambdaBlockExpr{
+		Symbols: []string{"$x", "$y"},
+		Body:    &RefExpr{Ref: "$y"},
+	}).(cl.Closure)
+	assert.Equal(t, 2, f.Arity())
+
+	g := f.Message(ctx, cl.StringValue{Text: "a"}).(cl.Closure)
+	assert.Equal(t, 1, g.Arity())
+	assert.Equal(t, []cl.Value{cl.StringValue{Text: "a"}}, g.Args)
+	assert.True(t, g.Transparent)
+	assert.Equal(t, 2, f.Arity(), "applying must not modify the closure")
+
+	h := g.Message(ctx, cl.StringValue{Text: "b"}).(cl.Closure)
+	assert.Equal(t, 0, h.Arity())
+	assert.Equal(t, cl.StringValue{Text: "b"}, cl.Force(ctx, h))
+}
+
+func TestApplyPreservesPurity(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	pure := counter(&calls, "$x")
+	pure.IsPure = true
+
+	applied := pure.Message(ctx, cl.NullValue{}).(cl.Closure)
+	assert.True(t, applied.IsPure)
+	assert.Equal(t, 0, calls)
+
+	// A saturated pure closure is called right away and the message goes to its result.
+	assert.Equal(t, cl.StringValue{Text: "1"}, applied.Message(ctx, cl.StringValue{Text: "a"}))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, cl.MapValue{"a": cl.StringValue{Text: "1"}}, cl.Force(ctx, applied))
+	assert.Equal(t, 2, calls)
+}
+
+func TestApplyDefersMessagesOfImpureClosures(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	f := counter(&calls, "$x")
+
+	v := f.Message(ctx, cl.NullValue{}).Message(ctx, cl.StringValue{Text: "a"})
+	c, ok := v.(cl.Closure)
+	assert.True(t, ok)
+	assert.Equal(t, []cl.Value{cl.StringValue{Text: "a"}}, c.PostRun)
+	assert.Equal(t, 0, calls)
+
+	// Deferring another message leaves the first closure as it was.
+	c.Message(ctx, cl.StringValue{Text: "b"})
+	assert.Len(t, c.PostRun, 1)
+
+	assert.Equal(t, cl.StringValue{Text: "1"}, cl.Run(ctx, c))
+	assert.Equal(t, 1, calls)
+}
+
+func TestOverApplication(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	// def $f $x $y = $x
+	env.Bind("$f", EvalDef(ctx, env, &DefStmt{
+		Ref:    "$f",
+		Params: []string{"$x", "$y"},
+		Body:   &RefExpr{Ref: "$x"},
+	}))
+	// def $adder $x = [$y | $y]
+	env.Bind("$adder", EvalDef(ctx, env, &DefStmt{
+		Ref:    "$adder",
+		Params: []string{"$x"},
+		Body:   &LambdaBlockExpr{Symbols: []string{"$y"}, Body: &RefExpr{Ref: "$y"}},
+	}))
+	env.Bind("$m", cl.MapValue{"a": cl.MapValue{"b": cl.StringValue{Text: "c"}}})
+	// $f a b c
+	err, ok := cl.AsError(cl.Force(ctx, EvalExpr(ctx, env, sends(ref("$f"), sym("a"), sym("b"), sym("c")))))
+	assert.True(t, ok)
+	assert.Equal(t, cl.ArityCode, err.Code)
+	assert.Equal(t, "<Closure $f:$x,$y> takes 2 arguments, got 3", err.ErrorMessage)
+
+	// ($f $m b) a b
+	grouped := sends(ref("$f"), ref("$m"), sym("b")).(*MessageExpr)
+	grouped.Grouped = true
+	v := EvalExpr(ctx, env, sends(grouped, sym("a"), sym("b")))
+	assert.Equal(t, cl.StringValue{Text: "c"}, cl.Force(ctx, v))
+
+	// $adder a b returns the function that takes b.
+	v = EvalExpr(ctx, env, sends(ref("$adder"), sym("a"), sym("b")))
+	assert.Equal(t, cl.StringValue{Text: "b"}, cl.Force(ctx, v))
+
+	// $f a b + x sends the operator to the result.
+	plus := &MessageExpr{
+		Receiver: sends(ref("$f"), sym("a"), sym("b"), sym("+")),
+		Message:  sym("x"),
+		Infix:    true,
+	}
+	assert.Equal(t, cl.StringValue{Text: "ax"}, cl.Force(ctx, EvalExpr(ctx, env, plus)))
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	// [$x $y | $x]
+	f := EvalExpr(ctx, cl.NewMutableEnv(), &LambdaBlockExpr{
+		Symbols: []string{"$x", "$y"},
+		Body:    &RefExpr{Ref: "$x"},
+	})
+	a, b := cl.StringValue{Text: "a"}, cl.StringValue{Text: "b"}
+
+	assert.Equal(t, a, cl.Force(ctx, cl.Apply(ctx, f, []cl.Value{a, b})))
+	assert.Equal(t, 1, cl.Apply(ctx, f, []cl.Value{a}).(cl.Function).Arity())
+	assert.Equal(t, 2, cl.Apply(ctx, f, nil).(cl.Function).Arity())
+
+	err, ok := cl.AsError(cl.Apply(ctx, f, []cl.Value{a, b, a}))
+	assert.True(t, ok)
+	assert.Equal(t, cl.ArityCode, err.Code)
+	assert.Equal(t, "<Closure:$x,$y> takes 2 arguments, got 3", err.ErrorMessage)
+
+	calls := 0
+	g := cl.DeferredValue(func() cl.Value { return counter(&calls) })
+	err, ok = cl.AsError(cl.Apply(ctx, g, []cl.Value{a}))
+	assert.True(t, ok)
+	assert.Equal(t, "<Closure counter> takes 0 arguments, got 1", err.ErrorMessage)
+	assert.Equal(t, 0, calls)
+
+	// Builtins such as the branches of conditionals are functions too.
+	then := cl.BoolValue{Bool: true}.Message(ctx, cl.StringValue{Text: "then"})
+	assert.Equal(t, 1, then.(cl.Function).Arity())
+	err, ok = cl.AsError(cl.Apply(ctx, then, []cl.Value{a, b}))
+	assert.True(t, ok)
+	assert.Equal(t, "<then:branch> takes 1 argument, got 2", err.ErrorMessage)
+	elseBranch := cl.Apply(ctx, then, []cl.Value{a}).Message(ctx, cl.StringValue{Text: "else"})
+	assert.Equal(t, 1, elseBranch.(cl.Function).Arity())
+	assert.Equal(t, a, cl.Force(ctx, cl.Apply(ctx, elseBranch, []cl.Value{b})))
+
+	// Values other than functions are sent the arguments as messages.
+	m := cl.MapValue{"a": cl.MapValue{"b": b}}
+	assert.Equal(t, b, cl.Apply(ctx, m, []cl.Value{a, b}))
+
+	// Errors among the arguments float out.
+	failure := cl.Error{ErrorMessage: "failure"}
+	assert.Equal(t, failure, cl.Apply(ctx, f, []cl.Value{failure, b}))
+}
@@ -3,10 +3,18 @@ package expr
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	cl "github.com/t0yv0/complang"
 )
 
+// sourceSpan locates e in the source of the expressions being evaluated, as set by cl.WithSource.
+func sourceSpan(ctx context.Context, e Expr) cl.Span {
+	span := Span(e)
+	span.Source = cl.SourceOf(ctx)
+	return span
+}
+
 func EvalExpr(ctx context.Context, env cl.Env, expr Expr) cl.Value {
 	switch expr := expr.(type) {
 	case *NullExpr:
@@ -15,6 +23,8 @@ func EvalExpr(ctx context.Context, env cl.Env, expr Expr) cl.Value {
 		return cl.BoolValue{Bool: expr.Bool}
 	case *StringExpr:
 		return cl.StringValue{Text: expr.String}
+	case *InterpolatedStringExpr:
+		return evalInterpolatedString(ctx, env, expr)
 	case *NumExpr:
 		return cl.NumValue{Num: expr.Number}
 	case *SymbolExpr:
@@ -24,11 +34,19 @@ func EvalExpr(ctx context.Context, env cl.Env, expr Expr) cl.Value {
 		if ok {
 			return v
 		}
-		return cl.Error{ErrorMessage: fmt.Sprintf("unbound symbol: %s", expr.Ref)}
+		span := sourceSpan(ctx, expr)
+		return cl.Error{
+			ErrorMessage: fmt.Sprintf("unbound symbol: %s", expr.Ref),
+			Code:         cl.UnboundRefCode,
+			Span:         &span,
+		}
 	case *MessageExpr:
-		receiver := EvalExpr(ctx, env, expr.Receiver)
-		message := EvalExpr(ctx, env, expr.Message)
-		return receiver.Message(ctx, message)
+		return evalMessageExpr(ctx, env, expr)
+	case *PipeExpr:
+		return EvalExpr(ctx, env, expr.Expand())
+	case *LetExpr:
+		v := cl.ForceTail(ctx, EvalExpr(ctx, env, expr.Value))
+		return EvalExpr(ctx, cl.ExtendEnv(env, expr.Ref, v), expr.Body)
 	case *LambdaBlockExpr:
 		body := expr.Body
 		return cl.Closure{
@@ -37,24 +55,183 @@ func EvalExpr(ctx context.Context, env cl.Env, expr Expr) cl.Value {
 			Call: func(ctx context.Context, env cl.Env) cl.Value {
 				return EvalExpr(ctx, env, body)
 			},
+			Transparent: true,
+			Source:      cl.SourceOf(ctx),
 		}
 	default:
 		panic("EvalExpr is incomplete")
 	}
 }
 
-func EvalStmt(ctx context.Context, env cl.MutableEnv, stmt Stmt) {
+// evalInterpolatedString renders embedded values with cl.Show, so strings embed their raw text.
+// Embedded expressions are forced but not run, and an error in any of them is the result.
+func evalInterpolatedString(ctx context.Context, env cl.Env, e *InterpolatedStringExpr) cl.Value {
+	var sb strings.Builder
+	for _, part := range e.Parts {
+		v := cl.Force(ctx, EvalExpr(ctx, env, part))
+		if cl.IsError(v) {
+			return v
+		}
+		sb.WriteString(cl.Show(ctx, v))
+	}
+	return cl.StringValue{Text: sb.String()}
+}
+
+// evalMessageExpr evaluates a chain of message sends `r m1 m2 ... mn` in a loop rather than
+// recursing on the receiver, so that long chains do not grow the stack. Errors produced by a send
+// are annotated with its source span and the sends leading up to it. Only the result of the last
+// send is in tail position and may be left for the caller to force. Go closures found along the
+// chain remember the expression that reached them as their Path.
+//
+// Applying a lambda block or def to more arguments than it takes fails with cl.ArityCode, unless it
+// returns another function that takes the rest. Messages for its result need parentheses, as in
+// `($f 1) name`, which the parser marks as Grouped; infix operators apply to the result as usual.
+func evalMessageExpr(ctx context.Context, env cl.Env, e *MessageExpr) cl.Value {
+	chain := []*MessageExpr{}
+	var root Expr = e
+	for {
+		m, ok := root.(*MessageExpr)
+		if !ok {
+			break
+		}
+		chain = append(chain, m)
+		root = m.Receiver
+	}
+	receiver := withPath(cl.ForceTail(ctx, EvalExpr(ctx, env, root)), root)
+	// fn is the function defined in complang that the arguments of the chain are being applied to,
+	// and applied counts them.
+	var fn cl.Function
+	applied := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		m := chain[i]
+		if !continuesApplication(chain, i) {
+			fn = nil
+		}
+		if c, ok := receiver.(cl.Closure); ok && fn == nil && isArgument(chain, i) && c.Transparent &&
+			c.Arity() > 0 {
+			fn, applied = c, 0
+		}
+		overApplied := false
+		if fn != nil && applied == fn.Arity() {
+			// Further arguments are only welcome if the function returns another function.
+			receiver = cl.Force(ctx, receiver)
+			if f, ok := receiver.(cl.Function); ok && f.Arity() > 0 {
+				fn, applied = f, 0
+			} else {
+				overApplied = !cl.IsError(receiver)
+			}
+		}
+		message := cl.ForceTail(ctx, EvalExpr(ctx, env, m.Message))
+		var result cl.Value
+		if overApplied {
+			result = cl.ArityError(ctx, fn, applied+extraArguments(chain, i))
+		} else {
+			result = receiver.Message(ctx, message)
+			applied++
+		}
+		if i > 0 {
+			result = cl.ForceTail(ctx, result)
+		}
+		result = withPath(result, m)
+		if cl.IsError(result) && !cl.IsError(receiver) && !cl.IsError(message) {
+			result = cl.AnnotateError(result, sourceSpan(ctx, m.Message), trace(chain[i:]))
+		}
+		receiver = result
+	}
+	return receiver
+}
+
+// isArgument checks if the send chain[i] applies an argument rather than being part of an infix
+// operator application, where the operator symbol is sent first and then the right operand.
+func isArgument(chain []*MessageExpr, i int) bool {
+	return !chain[i].Infix && (i == 0 || !chain[i-1].Infix)
+}
+
+// continuesApplication checks if the send chain[i] applies an argument to the same function as the
+// send before it, that is unless the receiver is grouped in parentheses.
+func continuesApplication(chain []*MessageExpr, i int) bool {
+	r, ok := chain[i].Receiver.(*MessageExpr)
+	return isArgument(chain, i) && !(ok && r.Grouped)
+}
+
+// extraArguments counts the arguments from chain[i] on that continue the same application.
+func extraArguments(chain []*MessageExpr, i int) int {
+	n := 1
+	for i--; i >= 0 && continuesApplication(chain, i); i-- {
+		n++
+	}
+	return n
+}
+
+// withPath records e as the path of v if v is a Go closure that does not have one yet.
+func withPath(v cl.Value, e Expr) cl.Value {
+	c, ok := v.(cl.Closure)
+	if !ok || c.Transparent || c.Path != "" {
+		return v
+	}
+	c.Path = String(e)
+	return c
+}
+
+// trace describes the sends of a chain, given innermost last, starting from the outermost receiver.
+func trace(chain []*MessageExpr) []cl.Send {
+	sends := []cl.Send{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		sends = append(sends, cl.Send{
+			Receiver: String(chain[i].Receiver),
+			Message:  String(chain[i].Message),
+		})
+	}
+	return sends
+}
+
+// EvalDef makes the closure for a function definition. Its environment binds the function's own name,
+// so that the body can call it recursively. Like lambda blocks, it takes its Source from ctx.
+func EvalDef(ctx context.Context, env cl.Env, def *DefStmt) cl.Closure {
+	var c cl.Closure
+	body := def.Body
+	c = cl.Closure{
+		Env:    cl.ExtendEnv(env, def.Ref, cl.DeferredValue(func() cl.Value { return c })),
+		Params: def.Params,
+		Call: func(ctx context.Context, env cl.Env) cl.Value {
+			return EvalExpr(ctx, env, body)
+		},
+		Name:        def.Ref,
+		Doc:         def.Doc,
+		Transparent: true,
+		Source:      cl.SourceOf(ctx),
+	}
+	return c
+}
+
+// EvalStmt evaluates a statement and runs its side-effects. Expression statements return the
+// resulting value for display; assignments and definitions bind it in env and return nil.
+func EvalStmt(ctx context.Context, env cl.MutableEnv, stmt Stmt) cl.Value {
+	var v cl.Value
+	switch stmt := stmt.(type) {
+	case *ExprStmt:
+		v = EvalExpr(ctx, env, stmt.Expr)
+	case *AssignStmt:
+		v = EvalExpr(ctx, env, stmt.Expr)
+	}
+	return RunStmt(ctx, env, stmt, v)
+}
+
+// RunStmt is the second half of EvalStmt: it runs the side-effects of v, the value of the
+// statement's expression as found by EvalExpr, and binds the result. It lets callers inspect v, for
+// example to plan its effects, without evaluating the expression twice. Definitions ignore v.
+func RunStmt(ctx context.Context, env cl.MutableEnv, stmt Stmt, v cl.Value) cl.Value {
 	switch stmt := stmt.(type) {
 	case *ExprStmt:
-		v := EvalExpr(ctx, env, stmt.Expr)
-		v = cl.Run(ctx, v) // run side-effects
-		fmt.Println(cl.Show(ctx, v))
+		return cl.Run(ctx, v) // run side-effects
 	case *AssignStmt:
-		v := EvalExpr(ctx, env, stmt.Expr)
-		v = cl.Run(ctx, v) // run side-effects
-		env.Bind(stmt.Ref, v)
+		env.Bind(stmt.Ref, cl.Run(ctx, v)) // run side-effects
+		return nil
+	case *DefStmt:
+		env.Bind(stmt.Ref, EvalDef(ctx, env, stmt))
+		return nil
 	default:
-		panic("EvalStmt is incomplete")
+		panic("RunStmt is incomplete")
 	}
 }
 
@@ -67,7 +244,11 @@ func EvalQuery(ctx context.Context, env cl.Env, q Query, complete func(string, s
 			Receiver: complete,
 		})
 	case *RefQuery:
-		cl.Complete(ctx, envToMap(env), cl.CompleteRequest{
+		refs := envToMap(env)
+		for _, local := range q.Locals {
+			refs[local] = cl.NullValue{}
+		}
+		cl.Complete(ctx, refs, cl.CompleteRequest{
 			Query:    q.Ref,
 			Receiver: complete,
 		})
@@ -76,7 +257,7 @@ func EvalQuery(ctx context.Context, env cl.Env, q Query, complete func(string, s
 	}
 }
 
-func envToMap(env cl.Env) cl.Value {
+func envToMap(env cl.Env) cl.MapValue {
 	v := make(cl.MapValue)
 	for _, s := range env.Symbols() {
 		if sv, ok := env.Lookup(s); ok {
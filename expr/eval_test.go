@@ -2,13 +2,81 @@ package expr
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/big"
 	"sort"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 	cl "github.com/t0yv0/complang"
 )
 
+// sends builds the message chain `receiver m1 m2 ...`.
+func sends(receiver Expr, messages ...Expr) Expr {
+	for _, m := range messages {
+		receiver = &MessageExpr{Receiver: receiver, Message: m}
+	}
+	return receiver
+}
+
+// op builds an infix send `left operator right`, as the parser desugars it.
+func op(left Expr, operator string, right Expr) Expr {
+	return &MessageExpr{Receiver: sends(left, sym(operator)), Message: right, Infix: true}
+}
+
+func ref(r string) Expr { return &RefExpr{Ref: r} }
+
+func sym(s string) Expr { return &SymbolExpr{Symbol: s} }
+
+func boolean(b bool) Expr { return &BoolExpr{Bool: b} }
+
+func str(s string) Expr { return &StringExpr{String: s} }
+
+// num builds a number literal from its decimal form.
+func num(n string) Expr {
+	r, _ := new(big.Rat).SetString(n)
+	return &NumExpr{Number: r}
+}
+
+// grouped marks a send as written in parentheses.
+func grouped(e Expr) Expr {
+	e.(*MessageExpr).Grouped = true
+	return e
+}
+
+// block builds the lambda block `[params | body]`.
+func block(body Expr, params ...string) Expr {
+	return &LambdaBlockExpr{Symbols: params, Body: body}
+}
+
+func pipe(left, right Expr) Expr { return &PipeExpr{Left: left, Right: right} }
+
+// let builds `let $ref = value | body`.
+func let(r string, value, body Expr) Expr { return &LetExpr{Ref: r, Value: value, Body: body} }
+
+// interpolate builds the string literal of the given parts, embedding those that are not strings.
+func interpolate(parts ...Expr) Expr { return &InterpolatedStringExpr{Parts: parts} }
+
+// evalCase is an expression, the code it prints as and how its value shows.
+type evalCase struct {
+	code     string
+	e        Expr
+	expected string
+}
+
+func testEval(t *testing.T, ctx context.Context, env cl.Env, cases []evalCase) {
+	for _, c := range cases {
+		t.Run(c.code, func(t *testing.T) {
+			assert.Equal(t, c.code, String(c.e))
+			assert.Equal(t, c.expected, cl.Show(ctx, cl.Run(ctx, EvalExpr(ctx, env, c.e))))
+		})
+	}
+}
+
 func TestEvalExpr(t *testing.T) {
 	ctx := context.Background()
 	s := "foo"
@@ -41,3 +109,616 @@ func TestEvalQuery(t *testing.T) {
 	sort.Strings(result)
 	assert.Equal(t, []string{foo1, foo2}, result)
 }
+
+type planTarget struct{}
+
+func (planTarget) Delete(name string) string {
+	panic("Delete must not be called while planning")
+}
+
+func (planTarget) ComplangEffect(method string) cl.Effect {
+	if method == "Delete" {
+		return cl.DestructiveEffect
+	}
+	return cl.UndeclaredEffect
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	env.Bind("$obj", cl.BindValue(planTarget{}))
+	// [$x | $obj Delete $x] foo
+	e := &MessageExpr{
+		Receiver: &LambdaBlockExpr{
+			Symbols: []string{"$x"},
+			Body: &MessageExpr{
+				Receiver: &MessageExpr{
+					Receiver: &RefExpr{Ref: "$obj"},
+					Message:  &SymbolExpr{Symbol: "Delete"},
+				},
+				Message: &RefExpr{Ref: "$x"},
+			},
+		},
+		Message: &SymbolExpr{Symbol: "foo"},
+	}
+	steps := cl.Plan(ctx, EvalExpr(ctx, env, e))
+	assert.Equal(t, []cl.PlanStep{{
+		Description: "call expr.planTarget.Delete foo",
+		Effect:      cl.DestructiveEffect,
+		Target:      "foo",
+	}}, steps)
+	assert.Equal(t, cl.DestructiveEffect, cl.StrongestEffect(steps))
+	assert.Empty(t, cl.Plan(ctx, EvalExpr(ctx, env, &SymbolExpr{Symbol: "foo"})))
+}
+
+func TestEffectLimit(t *testing.T) {
+	ctx := cl.WithEffectLimit(context.Background(), cl.ReadEffect)
+	called := false
+	c := cl.Closure{
+		Call: func(context.Context, cl.Env) cl.Value {
+			called = true
+			return cl.NullValue{}
+		},
+		Effect: cl.WriteEffect,
+	}
+	assert.True(t, cl.IsError(cl.Run(ctx, c)))
+	assert.False(t, called)
+
+	// Pure closures are exempt when their effect is undeclared, but not when it is declared stronger.
+	c.IsPure = true
+	assert.True(t, cl.IsError(cl.Run(ctx, c)))
+	assert.False(t, called)
+	c.Effect = cl.UndeclaredEffect
+	assert.Equal(t, cl.NullValue{}, cl.Run(ctx, c))
+	assert.True(t, called)
+
+	// Methods without arguments that declare stronger effects are neither called eagerly nor exempt.
+	var purged int
+	env := cl.NewMutableEnv()
+	env.Bind("$db", cl.BindValue(purgeTarget{&purged}))
+	// $db Purge
+	v := EvalExpr(ctx, env, &MessageExpr{Receiver: &RefExpr{Ref: "$db"}, Message: &SymbolExpr{Symbol: "Purge"}})
+	cl.Force(context.Background(), v)
+	assert.Equal(t, 0, purged)
+	assert.True(t, cl.IsError(cl.Run(ctx, v)))
+	assert.Equal(t, 0, purged)
+	cl.Run(context.Background(), v)
+	assert.Equal(t, 1, purged)
+}
+
+type purgeTarget struct{ purged *int }
+
+func (p purgeTarget) Purge() {
+	*p.purged++
+}
+
+func (purgeTarget) ComplangEffect(method string) cl.Effect {
+	return cl.DestructiveEffect
+}
+
+func TestRunObserver(t *testing.T) {
+	var observed []string
+	ctx := cl.WithRunObserver(context.Background(), func(ctx context.Context, c cl.Closure, r cl.Value) {
+		observed = append(observed, c.Name+" "+cl.Show(ctx, c.Args[0])+" -> "+cl.Show(ctx, r))
+	})
+	env := cl.NewMutableEnv()
+	env.Bind("$f", cl.Closure{
+		Params: []string{"$x"},
+		Call: func(ctx context.Context, env cl.Env) cl.Value {
+			x, _ := env.Lookup("$x")
+			return x
+		},
+		Name: "f",
+	})
+	// [$y | $f $y] a
+	e := &MessageExpr{
+		Receiver: &LambdaBlockExpr{
+			Symbols: []string{"$y"},
+			Body: &MessageExpr{
+				Receiver: &RefExpr{Ref: "$f"},
+				Message:  &RefExpr{Ref: "$y"},
+			},
+		},
+		Message: &SymbolExpr{Symbol: "a"},
+	}
+	cl.Run(ctx, EvalExpr(ctx, env, e))
+	assert.Equal(t, []string{"f a -> a"}, observed)
+
+	// Closures refused by the effect limit are observed with the refusal.
+	observed = nil
+	cl.Run(cl.WithEffectLimit(ctx, cl.ReadEffect), EvalExpr(ctx, env, e))
+	assert.Equal(t, []string{"f a -> ERROR: refusing to run f: write effects are not allowed"}, observed)
+
+	// Pure closures are observed when they declare effects stronger than reads.
+	observed = nil
+	for _, effect := range []cl.Effect{cl.ReadEffect, cl.DestructiveEffect} {
+		cl.Run(ctx, cl.Closure{
+			Call:   func(context.Context, cl.Env) cl.Value { return cl.NullValue{} },
+			IsPure: true,
+			Name:   "purge",
+			Args:   []cl.Value{cl.StringValue{Text: effect.String()}},
+			Effect: effect,
+		})
+	}
+	assert.Equal(t, []string{"purge destructive -> null"}, observed)
+}
+
+func TestPath(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	env.Bind("$obj", cl.BindValue(planTarget{}))
+	// $obj Delete foo
+	e := &MessageExpr{
+		Receiver: &MessageExpr{
+			Receiver: &RefExpr{Ref: "$obj"},
+			Message:  &SymbolExpr{Symbol: "Delete"},
+		},
+		Message: &SymbolExpr{Symbol: "foo"},
+	}
+	assert.Equal(t, "$obj Delete", EvalExpr(ctx, env, e).(cl.Closure).Path)
+}
+
+var errMissing = errors.New("missing")
+
+type errorTarget struct{}
+
+func (errorTarget) Find(name string) (string, error) {
+	return "", fmt.Errorf("finding %s: %w", name, errMissing)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	env.Bind("$obj", cl.BindValue(errorTarget{}))
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}})
+
+	t.Run("wraps Go errors", func(t *testing.T) {
+		// $obj Find x
+		e := &MessageExpr{
+			Receiver: &MessageExpr{
+				Receiver: &RefExpr{Ref: "$obj", Offset: 0, Length: 4},
+				Message:  &SymbolExpr{Symbol: "Find", Offset: 5, Length: 4},
+			},
+			Message: &SymbolExpr{Symbol: "x", Offset: 10, Length: 1},
+		}
+		v := cl.Run(ctx, EvalExpr(ctx, env, e))
+		err, ok := cl.AsError(v)
+		assert.True(t, ok)
+		assert.Equal(t, cl.GoErrorCode, err.Code)
+		assert.True(t, errors.Is(err, errMissing))
+	})
+
+	t.Run("records span and trace", func(t *testing.T) {
+		// $m a b
+		e := &MessageExpr{
+			Receiver: &MessageExpr{
+				Receiver: &RefExpr{Ref: "$m", Offset: 0, Length: 2},
+				Message:  &SymbolExpr{Symbol: "a", Offset: 3, Length: 1},
+			},
+			Message: &SymbolExpr{Symbol: "b", Offset: 5, Length: 1},
+		}
+		err, ok := cl.AsError(EvalExpr(cl.WithSource(ctx, "$m a b"), env, e))
+		assert.True(t, ok)
+		assert.Equal(t, cl.DoesNotUnderstandCode, err.Code)
+		assert.Equal(t, &cl.Span{Offset: 5, Length: 1, Source: "$m a b"}, err.Span)
+		assert.Equal(t, []cl.Send{
+			{Receiver: "$m", Message: "a"},
+			{Receiver: "$m a", Message: "b"},
+		}, err.Trace)
+	})
+
+	t.Run("spans refer to the source of functions", func(t *testing.T) {
+		// def $f = $m b
+		def := &DefStmt{
+			Ref: "$f",
+			Body: &MessageExpr{
+				Receiver: &RefExpr{Ref: "$m", Offset: 9, Length: 2},
+				Message:  &SymbolExpr{Symbol: "b", Offset: 12, Length: 1},
+			},
+		}
+		env := cl.NewMutableEnv()
+		env.Bind("$m", cl.MapValue{})
+		env.Bind("$f", EvalDef(cl.WithSource(ctx, "def $f = $m b"), env, def))
+		v := cl.Run(cl.WithSource(ctx, "$f"), EvalExpr(ctx, env, &RefExpr{Ref: "$f", Length: 2}))
+		err, ok := cl.AsError(v)
+		assert.True(t, ok)
+		assert.Equal(t, &cl.Span{Offset: 12, Length: 1, Source: "def $f = $m b"}, err.Span)
+	})
+
+	t.Run("truncates long values by runes", func(t *testing.T) {
+		msg := strings.Repeat("é", 100)
+		err, ok := cl.AsError(EvalExpr(ctx, env, &MessageExpr{
+			Receiver: &RefExpr{Ref: "$m"},
+			Message:  &StringExpr{String: msg},
+		}))
+		assert.True(t, ok)
+		assert.True(t, utf8.ValidString(err.ErrorMessage))
+		assert.Equal(t, "object map{a} does not understand "+strings.Repeat("é", 60)+"...", err.ErrorMessage)
+	})
+}
+
+type numTarget struct {
+	Count uint64
+	Ratio float64
+}
+
+func (numTarget) Repeat(s string, n int) []string {
+	r := []string{}
+	for i := 0; i < n; i++ {
+		r = append(r, s)
+	}
+	return r
+}
+
+func TestBindNumbers(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	env.Bind("$obj", cl.BindValue(numTarget{Count: 1<<64 - 1, Ratio: 0.1}))
+	show := func(e Expr) string {
+		return cl.Show(ctx, cl.Run(ctx, EvalExpr(ctx, env, e)))
+	}
+	field := func(name string) Expr {
+		return &MessageExpr{Receiver: &RefExpr{Ref: "$obj"}, Message: &SymbolExpr{Symbol: name}}
+	}
+	repeat := func(n *big.Rat) Expr {
+		return &MessageExpr{
+			Receiver: &MessageExpr{Receiver: field("Repeat"), Message: &SymbolExpr{Symbol: "a"}},
+			Message:  &NumExpr{Number: n},
+		}
+	}
+	assert.Equal(t, "18446744073709551615", show(field("Count")))
+	assert.Equal(t, "0.1", show(field("Ratio")))
+	assert.Len(t, cl.Run(ctx, EvalExpr(ctx, env, repeat(big.NewRat(2, 1)))), 2)
+	assert.Equal(t, "ERROR: Cannot pass 0.5 as int", show(repeat(big.NewRat(1, 2))))
+}
+
+func TestLet(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+	env.Bind("$x", cl.StringValue{Text: "outer"})
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}, "b": cl.StringValue{Text: "2"}})
+
+	// $y = let $x = $m a | [$z | $x]
+	stmt := &AssignStmt{Ref: "$y", Expr: &LetExpr{
+		Ref:   "$x",
+		Value: &MessageExpr{Receiver: &RefExpr{Ref: "$m"}, Message: &SymbolExpr{Symbol: "a"}},
+		Body:  &LambdaBlockExpr{Symbols: []string{"$z"}, Body: &RefExpr{Ref: "$x"}},
+	}}
+	assert.Nil(t, EvalStmt(ctx, env, stmt))
+	y, ok := env.Lookup("$y")
+	assert.True(t, ok)
+	assert.Equal(t, cl.StringValue{Text: "1"}, cl.Force(ctx, y.Message(ctx, cl.NullValue{})))
+	x, _ := env.Lookup("$x")
+	assert.Equal(t, cl.StringValue{Text: "outer"}, x)
+	assert.Equal(t, []string{"$m", "$x", "$y"}, env.Symbols())
+
+	m := func(key string) Expr { return sends(ref("$m"), sym(key)) }
+	testEval(t, ctx, env, []evalCase{
+		{
+			`let $n = $m a | "${$n}${$n}"`,
+			let("$n", m("a"), interpolate(ref("$n"), ref("$n"))),
+			"11",
+		},
+		{
+			"let $a = $m a | let $b = $m b | $a + $b",
+			let("$a", m("a"), let("$b", m("b"), op(ref("$a"), "+", ref("$b")))),
+			"12",
+		},
+		{
+			`[$x | let $y = $x + "!" | $y + $y] "hi"`,
+			sends(block(let("$y", op(ref("$x"), "+", str("!")), op(ref("$y"), "+", ref("$y"))), "$x"),
+				str("hi")),
+			"hi!hi!",
+		},
+		{"let $m = $m b | $m", let("$m", m("b"), ref("$m")), "2"},
+		{
+			`let $x = $m a | $x |> [$s | $s + "?"]`,
+			let("$x", m("a"), pipe(ref("$x"), block(op(ref("$s"), "+", str("?")), "$s"))),
+			"1?",
+		},
+		{
+			"(let $x = 2 | $x * $x) + 1",
+			op(let("$x", num("2"), op(ref("$x"), "*", ref("$x"))), "+", num("1")),
+			"5",
+		},
+		{
+			"let $x = $m c | $x",
+			let("$x", m("c"), ref("$x")),
+			"ERROR: object map{a, b} does not understand c",
+		},
+	})
+}
+
+func TestDef(t *testing.T) {
+	ctx := context.Background()
+	env := cl.NewMutableEnv()
+
+	// def $f $n "Returns itself." = $f
+	def := &DefStmt{Ref: "$f", Params: []string{"$n"}, Doc: "Returns itself.", Body: &RefExpr{Ref: "$f"}}
+	assert.Nil(t, EvalStmt(ctx, env, def))
+	f, ok := env.Lookup("$f")
+	assert.True(t, ok)
+	c, ok := f.(cl.Closure)
+	assert.True(t, ok)
+	assert.Equal(t, "$f $n", c.Signature())
+	assert.Equal(t, "Returns itself.", c.Doc)
+
+	// The body refers to the function itself, even after $f is rebound.
+	env.Bind("$f", cl.NullValue{})
+	self, ok := cl.Force(ctx, c.Message(ctx, cl.NullValue{})).(cl.Closure)
+	assert.True(t, ok)
+	assert.Equal(t, "$f $n", self.Signature())
+
+	env = cl.NewMutableEnv()
+	n := ref("$n")
+	for _, def := range []*DefStmt{
+		// def $fact $n = ($n <= 1) then [1] else [$n * $fact ($n - 1)]
+		{Ref: "$fact", Params: []string{"$n"}, Body: sends(grouped(op(n, "<=", num("1"))),
+			sym("then"), block(num("1")),
+			sym("else"), block(op(n, "*", sends(ref("$fact"), grouped(op(n, "-", num("1"))))))),
+		},
+		// def $join $a $b = "${$a}${$b}"
+		{Ref: "$join", Params: []string{"$a", "$b"}, Body: interpolate(ref("$a"), ref("$b"))},
+	} {
+		assert.Nil(t, EvalStmt(ctx, env, def))
+	}
+	testEval(t, ctx, env, []evalCase{
+		{"$fact 5", sends(ref("$fact"), num("5")), "120"},
+		{"$fact 20", sends(ref("$fact"), num("20")), "2432902008176640000"},
+		{"$join a", sends(ref("$join"), sym("a")), "<Closure $join:$b>"},
+		{"$join a b", sends(ref("$join"), sym("a"), sym("b")), "ab"},
+		{
+			"4 |> $fact |> $join x",
+			pipe(pipe(num("4"), ref("$fact")), sends(ref("$join"), sym("x"))),
+			"x24",
+		},
+		{"let $fact = 0 | $fact", let("$fact", num("0"), ref("$fact")), "0"},
+	})
+}
+
+func TestMaxDepth(t *testing.T) {
+	ctx := cl.WithMaxDepth(context.Background(), 10)
+	done := cl.StringValue{Text: "done"}
+
+	// countdown makes a closure that calls the one for n-1, either nested or in tail position.
+	var countdown func(n int, tail bool) cl.Value
+	countdown = func(n int, tail bool) cl.Value {
+		return cl.Closure{
+			Call: func(ctx context.Context, _ cl.Env) cl.Value {
+				switch {
+				case n == 0:
+					return done
+				case tail:
+					return cl.Tail(countdown(n-1, tail))
+				default:
+					return cl.Force(ctx, countdown(n-1, tail))
+				}
+			},
+			Transparent: true,
+		}
+	}
+	assert.Equal(t, done, cl.Force(ctx, countdown(9, false)))
+	assert.Equal(t, done, cl.Run(ctx, countdown(9, false)))
+	err, ok := cl.AsError(cl.Force(ctx, countdown(10, false)))
+	assert.True(t, ok)
+	assert.Equal(t, cl.MaxDepthCode, err.Code)
+	assert.Equal(t, "maximum call depth of 10 exceeded", err.ErrorMessage)
+
+	assert.Equal(t, done, cl.Force(ctx, countdown(1000, true)))
+	assert.Equal(t, done, cl.Run(ctx, countdown(1000, true)))
+	assert.Empty(t, cl.Plan(ctx, countdown(1000, true)))
+	assert.Equal(t, done, cl.ForceTail(ctx, cl.Tail(countdown(1000, true))))
+}
+
+func TestRecursion(t *testing.T) {
+	ctx := cl.WithMaxDepth(context.Background(), 1000)
+	env := cl.NewMutableEnv()
+	n := ref("$n")
+	// cond builds `(test) then [yes] else [no]`.
+	cond := func(test, yes, no Expr) Expr {
+		return sends(grouped(test), sym("then"), block(yes), sym("else"), block(no))
+	}
+	// recur builds `$f ($n - 1)`.
+	recur := func(f string) Expr { return sends(ref(f), grouped(op(n, "-", num("1")))) }
+	for _, def := range []*DefStmt{
+		// def $count $n = ($n > 0) then [$count ($n - 1)] else [done]
+		{Ref: "$count", Params: []string{"$n"},
+			Body: cond(op(n, ">", num("0")), recur("$count"), sym("done"))},
+		// def $sum $n = ($n <= 0) then [0] else [$n + $sum ($n - 1)]
+		{Ref: "$sum", Params: []string{"$n"},
+			Body: cond(op(n, "<=", num("0")), num("0"), op(n, "+", recur("$sum")))},
+		// def $even $n = ($n == 0) then [true] else [$odd ($n - 1)]
+		{Ref: "$even", Params: []string{"$n"},
+			Body: cond(op(n, "==", num("0")), boolean(true), recur("$odd"))},
+		// def $odd $n = ($n == 0) then [false] else [$even ($n - 1)]
+		{Ref: "$odd", Params: []string{"$n"},
+			Body: cond(op(n, "==", num("0")), boolean(false), recur("$even"))},
+	} {
+		assert.Nil(t, EvalStmt(ctx, env, def))
+	}
+	count := sends(ref("$count"), num("10000"))
+	testEval(t, ctx, env, []evalCase{
+		{"$count 20000", sends(ref("$count"), num("20000")), "done"},
+		{"$even 10001", sends(ref("$even"), num("10001")), "false"},
+		{`"${$count 10000}"`, interpolate(count), "done"},
+		{"let $x = $count 10000 | $x", let("$x", count, ref("$x")), "done"},
+		{"$sum 100", sends(ref("$sum"), num("100")), "5050"},
+		{
+			"$sum 1000",
+			sends(ref("$sum"), num("1000")),
+			"ERROR: maximum call depth of 1000 exceeded",
+		},
+	})
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	env := cl.NewMutableEnv()
+
+	// def $f $n = $f $n
+	def := &DefStmt{Ref: "$f", Params: []string{"$n"}, Body: &MessageExpr{
+		Receiver: &RefExpr{Ref: "$f"},
+		Message:  &RefExpr{Ref: "$n"},
+	}}
+	assert.Nil(t, EvalStmt(ctx, env, def))
+
+	// $f 1 never returns, so each loop runs until the context is done.
+	loop := &MessageExpr{Receiver: &RefExpr{Ref: "$f"}, Message: &NumExpr{Number: big.NewRat(1, 1)}}
+	assert.Empty(t, cl.Plan(ctx, EvalExpr(ctx, env, loop)))
+	// Deadlines set inside a call are seen by the calls nested in it.
+	bounded := cl.Closure{
+		Call: func(ctx context.Context, _ cl.Env) cl.Value {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			return cl.Run(ctx, EvalExpr(ctx, env, loop))
+		},
+		Name: "bounded",
+	}
+	for _, v := range []cl.Value{
+		cl.Run(ctx, EvalExpr(ctx, env, loop)),
+		cl.Force(ctx, EvalExpr(ctx, env, loop)),
+		cl.Run(context.Background(), bounded),
+	} {
+		err, ok := cl.AsError(v)
+		assert.True(t, ok)
+		assert.Equal(t, cl.CancelledCode, err.Code)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	env := cl.NewMutableEnv()
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}, "b": cl.StringValue{Text: "2"}})
+	m := func(key string) Expr { return sends(ref("$m"), sym(key)) }
+	testEval(t, context.Background(), env, []evalCase{
+		{"true not", sends(boolean(true), sym("not")), "false"},
+		{"true and [false]", sends(boolean(true), sym("and"), block(boolean(false))), "false"},
+		{"false and [$m c]", sends(boolean(false), sym("and"), block(m("c"))), "false"},
+		{"true or [$m c]", sends(boolean(true), sym("or"), block(m("c"))), "true"},
+		{"false or [true]", sends(boolean(false), sym("or"), block(boolean(true))), "true"},
+		{
+			"true and [$m a]",
+			sends(boolean(true), sym("and"), block(m("a"))),
+			"ERROR: expected a bool, got 1",
+		},
+		{
+			"true then [$m a] else [$m c]",
+			sends(boolean(true), sym("then"), block(m("a")), sym("else"), block(m("c"))),
+			"1",
+		},
+		{
+			"false then [$m c] else [$m b]",
+			sends(boolean(false), sym("then"), block(m("c")), sym("else"), block(m("b"))),
+			"2",
+		},
+		{"false then [$m c]", sends(boolean(false), sym("then"), block(m("c"))), "null"},
+	})
+}
+
+func TestNumbers(t *testing.T) {
+	testEval(t, context.Background(), cl.NewMutableEnv(), []evalCase{
+		{`1 "+" 2`, sends(num("1"), str("+"), num("2")), "3"},
+		{
+			"(2 / 3) fixed 3",
+			sends(grouped(op(num("2"), "/", num("3"))), sym("fixed"), num("3")),
+			"0.667",
+		},
+		{
+			"(2 / 3) float",
+			sends(grouped(op(num("2"), "/", num("3"))), sym("float")),
+			"0.6666666666666666",
+		},
+		{"1 / 8", op(num("1"), "/", num("8")), "0.125"},
+		{"1 / 0", op(num("1"), "/", num("0")), "ERROR: division of 1 by zero"},
+		{
+			"12345678901234567890 * 10",
+			op(num("12345678901234567890"), "*", num("10")),
+			"123456789012345678900",
+		},
+	})
+}
+
+func TestOperators(t *testing.T) {
+	env := cl.NewMutableEnv()
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}, "b": cl.StringValue{Text: "2"}})
+	m := func(key string) Expr { return sends(ref("$m"), sym(key)) }
+	testEval(t, context.Background(), env, []evalCase{
+		{"1 + 2 * 3", op(num("1"), "+", op(num("2"), "*", num("3"))), "7"},
+		{"(1 + 2) * 3", op(grouped(op(num("1"), "+", num("2"))), "*", num("3")), "9"},
+		{"10 - 4 - 3", op(op(num("10"), "-", num("4")), "-", num("3")), "3"},
+		{"1 / 3 + 1 / 6", op(op(num("1"), "/", num("3")), "+", op(num("1"), "/", num("6"))), "0.5"},
+		{"1 + 1 == 2", op(op(num("1"), "+", num("1")), "==", num("2")), "true"},
+		{"0.1 + 0.2 == 0.3", op(op(num("0.1"), "+", num("0.2")), "==", num("0.3")), "true"},
+		{
+			"(1 < 2) and [2 >= 3]",
+			sends(grouped(op(num("1"), "<", num("2"))), sym("and"), block(op(num("2"), ">=", num("3")))),
+			"false",
+		},
+		{"$m a + $m b", op(m("a"), "+", m("b")), "12"},
+		{`$m a == "1"`, op(m("a"), "==", str("1")), "true"},
+		{"$m a != $m b", op(m("a"), "!=", m("b")), "true"},
+		{"true == false", op(boolean(true), "==", boolean(false)), "false"},
+		{"1 - a", op(num("1"), "-", sym("a")), "ERROR: expected a number, got a"},
+		{"$m a + 1", op(m("a"), "+", num("1")), "ERROR: expected a string, got 1"},
+		{"$m c + 1", op(m("c"), "+", num("1")), "ERROR: object map{a, b} does not understand c"},
+		{
+			"(1 + 2 > 2) then [big] else [no]",
+			sends(grouped(op(op(num("1"), "+", num("2")), ">", num("2"))),
+				sym("then"), block(sym("big")), sym("else"), block(sym("no"))),
+			"big",
+		},
+	})
+}
+
+func TestPipelines(t *testing.T) {
+	env := cl.NewMutableEnv()
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}, "b": cl.StringValue{Text: "2"}})
+	first := block(sends(ref("$x"), sym("a")), "$x")
+	concat := block(op(ref("$x"), "+", ref("$y")), "$x", "$y")
+	testEval(t, context.Background(), env, []evalCase{
+		{"$m |> [$x | $x a]", pipe(ref("$m"), first), "1"},
+		{
+			`$m |> [$x | $x a] |> [$x | $x + "!"]`,
+			pipe(pipe(ref("$m"), first), block(op(ref("$x"), "+", str("!")), "$x")),
+			"1!",
+		},
+		{"$m |> a", pipe(ref("$m"), sym("a")), "ERROR: object a does not understand map{a, b}"},
+		{"b |> [$x $y | $x + $y] a", pipe(sym("b"), sends(concat, sym("a"))), "ab"},
+		{
+			"[$x $y | $x + $y] a |> [$s | $s b]",
+			pipe(sends(concat, sym("a")), block(sends(ref("$s"), sym("b")), "$s")),
+			"ab",
+		},
+	})
+}
+
+func TestInterpolation(t *testing.T) {
+	env := cl.NewMutableEnv()
+	env.Bind("$m", cl.MapValue{"a": cl.StringValue{Text: "1"}, "b": cl.StringValue{Text: "2"}})
+	m := func(key string) Expr { return sends(ref("$m"), sym(key)) }
+	testEval(t, context.Background(), env, []evalCase{
+		{`"a is ${$m a}"`, interpolate(str("a is "), m("a")), "a is 1"},
+		{`"${$m a}${$m b}"`, interpolate(m("a"), m("b")), "12"},
+		{
+			`"sum: ${1 / 2 + 1}, ok: ${1 < 2}"`,
+			interpolate(
+				str("sum: "), op(op(num("1"), "/", num("2")), "+", num("1")),
+				str(", ok: "), op(num("1"), "<", num("2")),
+			),
+			"sum: 1.5, ok: true",
+		},
+		{
+			`"nested ${"${$m a}!"}"`,
+			interpolate(str("nested "), interpolate(m("a"), str("!"))),
+			"nested 1!",
+		},
+		{`"\${$m a} costs $5"`, str("${$m a} costs $5"), "${$m a} costs $5"},
+		{
+			`"missing ${$m c}"`,
+			interpolate(str("missing "), m("c")),
+			"ERROR: object map{a, b} does not understand c",
+		},
+		{`"lazy ${[$m b]}"`, interpolate(str("lazy "), block(m("b"))), "lazy 2"},
+	})
+}
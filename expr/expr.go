@@ -1,6 +1,10 @@
 package expr
 
-import "math/big"
+import (
+	"math/big"
+
+	cl "github.com/t0yv0/complang"
+)
 
 type Expr interface {
 	exprMarker()
@@ -11,6 +15,8 @@ type RefExpr struct {
 	Ref string
 	// Character-based offset of the start of the symbol appearance in the source code.
 	Offset int
+	// Character-based length of the source code of the expression.
+	Length int
 }
 
 var _ Expr = (*RefExpr)(nil)
@@ -19,6 +25,14 @@ type MessageExpr struct {
 	exprMarkerImpl
 	Receiver Expr
 	Message  Expr
+	// Infix marks sends parsed from infix operators. The parser desugars `a + b` to the send of b to
+	// the result of sending the operator symbol to a, that is `(a +) b`; Infix is set on the outer
+	// send.
+	Infix bool
+	// Grouped marks sends other than infix operators written in parentheses as the receiver of
+	// further sends, as in `($f 1) name`. Applying a function to arguments ends at a grouped send,
+	// so the sends after it go to its result.
+	Grouped bool
 }
 
 var _ Expr = (*MessageExpr)(nil)
@@ -28,19 +42,25 @@ type SymbolExpr struct {
 	Symbol string
 	// Character-based offset of the start of the symbol appearance in the source code.
 	Offset int
+	// Character-based length of the source code of the expression.
+	Length int
 }
 
 var _ Expr = (*SymbolExpr)(nil)
 
 type NullExpr struct {
 	exprMarkerImpl
+	Offset int
+	Length int
 }
 
 var _ Expr = (*NullExpr)(nil)
 
 type BoolExpr struct {
 	exprMarkerImpl
-	Bool bool
+	Bool   bool
+	Offset int
+	Length int
 }
 
 var _ Expr = (*BoolExpr)(nil)
@@ -48,13 +68,28 @@ var _ Expr = (*BoolExpr)(nil)
 type StringExpr struct {
 	exprMarkerImpl
 	String string
+	Offset int
+	Length int
 }
 
 var _ Expr = (*StringExpr)(nil)
 
+// InterpolatedStringExpr is a string literal with embedded expressions, such as "host: ${$h name}".
+// Parts are StringExpr for the literal text and arbitrary expressions for the embedded ones.
+type InterpolatedStringExpr struct {
+	exprMarkerImpl
+	Parts  []Expr
+	Offset int
+	Length int
+}
+
+var _ Expr = (*InterpolatedStringExpr)(nil)
+
 type NumExpr struct {
 	exprMarkerImpl
-	Number *big.Float
+	Number *big.Rat
+	Offset int
+	Length int
 }
 
 var _ Expr = (*NumExpr)(nil)
@@ -63,10 +98,76 @@ type LambdaBlockExpr struct {
 	exprMarkerImpl
 	Symbols []string
 	Body    Expr
+	Offset  int
+	Length  int
 }
 
 var _ Expr = (*LambdaBlockExpr)(nil)
 
+// LetExpr binds the value of an expression to a ref within the body, as in `let $x = $y size | $x * $x`.
+// The body extends as far to the right as possible.
+type LetExpr struct {
+	exprMarkerImpl
+	Ref    string
+	Value  Expr
+	Body   Expr
+	Offset int
+	Length int
+}
+
+var _ Expr = (*LetExpr)(nil)
+
+// PipeExpr is a pipeline stage `Left |> Right`, which feeds the value of Left to Right. Pipelines
+// are only parsed at the top level of statements.
+type PipeExpr struct {
+	exprMarkerImpl
+	Left  Expr
+	Right Expr
+	// Character-based offset of the |> operator in the source code.
+	Offset int
+}
+
+var _ Expr = (*PipeExpr)(nil)
+
+// Expand desugars the stage into a message send, passing Left as the last argument of Right, so that
+// `$x |> $f a` is `$f a $x`.
+func (p *PipeExpr) Expand() Expr {
+	return &MessageExpr{Receiver: p.Right, Message: p.Left}
+}
+
+// Span locates an expression in the source code. Message expressions span from the start of their
+// receiver to the end of their message.
+func Span(e Expr) cl.Span {
+	switch e := e.(type) {
+	case *RefExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *SymbolExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *NullExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *BoolExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *StringExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *InterpolatedStringExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *NumExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *LambdaBlockExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *LetExpr:
+		return cl.Span{Offset: e.Offset, Length: e.Length}
+	case *MessageExpr:
+		r, m := Span(e.Receiver), Span(e.Message)
+		return cl.Span{Offset: r.Offset, Length: m.Offset + m.Length - r.Offset}
+	case *PipeExpr:
+		l, r := Span(e.Left), Span(e.Right)
+		return cl.Span{Offset: l.Offset, Length: r.Offset + r.Length - l.Offset}
+	default:
+		return cl.Span{}
+	}
+}
+
 type exprMarkerImpl struct{}
 
 func (*exprMarkerImpl) exprMarker() {}
@@ -90,6 +191,18 @@ type AssignStmt struct {
 
 var _ Stmt = (*AssignStmt)(nil)
 
+// DefStmt defines a named function, as in `def $f $x $y "doc" = body`. Unlike a lambda bound with an
+// assignment, the body can refer to the function itself by name.
+type DefStmt struct {
+	stmtMarkerImpl
+	Ref    string
+	Params []string
+	Doc    string
+	Body   Expr
+}
+
+var _ Stmt = (*DefStmt)(nil)
+
 type stmtMarkerImpl struct{}
 
 func (*stmtMarkerImpl) stmtMarker() {}
@@ -123,6 +236,10 @@ type RefQuery struct {
 	queryMarkerImpl
 	Ref       string
 	RefOffset int
+
+	// Locals lists the refs that are bound where the ref is being typed but not in the environment,
+	// such as the parameters of an enclosing lambda or def.
+	Locals []string
 }
 
 var _ Query = (*RefQuery)(nil)
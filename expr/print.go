@@ -0,0 +1,344 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// Precedence returns the precedence of an infix operator, or 0 if op is not an operator. Operators
+// bind looser than juxtaposition and those of the same precedence associate to the left.
+func Precedence(op string) int {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=":
+		return 1
+	case "+", "-":
+		return 2
+	case "*", "/":
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Precedence of expressions, extending the precedence of infix operators.
+const (
+	pipePrecedence        = 0
+	applicationPrecedence = 4
+	simplePrecedence      = 5
+)
+
+// String renders an Expr, Stmt or Query as canonical source code on a single line, with single
+// spaces between sends and only the parentheses that precedence requires. Parsing the result gives
+// back an equivalent tree, except for numbers that are not finite decimals, which print as fractions
+// such as 1/3.
+func String(node any) string {
+	switch node := node.(type) {
+	case Expr:
+		return format(node)
+	case *ExprStmt:
+		return format(node.Expr)
+	case *AssignStmt:
+		return node.Ref + " = " + format(node.Expr)
+	case *DefStmt:
+		return defHead(node) + format(node.Body)
+	case *SymbolQuery:
+		if node.Symbol == "" {
+			return format(node.Expr) + " "
+		}
+		return format(node.Expr) + " " + QuoteSymbol(node.Symbol)
+	case *RefQuery:
+		return node.Ref
+	default:
+		panic(fmt.Sprintf("String is incomplete, got %#T", node))
+	}
+}
+
+// Format is like String, but wraps statements and expressions longer than width characters onto
+// continuation lines indented by four spaces, breaking before each |> stage of a pipeline, or else
+// before each message of a chain of sends.
+func Format(node any, width int) string {
+	switch node := node.(type) {
+	case Expr:
+		return wrap("", node, width)
+	case *ExprStmt:
+		return wrap("", node.Expr, width)
+	case *AssignStmt:
+		return wrap(node.Ref+" = ", node.Expr, width)
+	case *DefStmt:
+		return wrap(defHead(node), node.Body, width)
+	default:
+		return String(node)
+	}
+}
+
+// defHead renders a definition up to and including the '='.
+func defHead(d *DefStmt) string {
+	var sb strings.Builder
+	sb.WriteString("def " + d.Ref)
+	for _, p := range d.Params {
+		sb.WriteString(" " + p)
+	}
+	if d.Doc != "" {
+		sb.WriteString(` "` + escapeString(d.Doc) + `"`)
+	}
+	sb.WriteString(" = ")
+	return sb.String()
+}
+
+func wrap(prefix string, e Expr, width int) string {
+	const indent = "    "
+	line := prefix + format(e)
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	var sb strings.Builder
+	switch e := e.(type) {
+	case *PipeExpr:
+		stages := []Expr{}
+		var left Expr = e
+		for {
+			p, ok := left.(*PipeExpr)
+			if !ok {
+				break
+			}
+			stages = append(stages, p.Right)
+			left = p.Left
+		}
+		sb.WriteString(prefix + format(left))
+		for i := len(stages) - 1; i >= 0; i-- {
+			sb.WriteString("\n" + indent + "|> " + operand(stages[i], pipePrecedence+1))
+		}
+	case *MessageExpr:
+		messages := []Expr{}
+		var root Expr = e
+		for {
+			m, ok := root.(*MessageExpr)
+			if !ok {
+				break
+			}
+			if _, _, _, ok := infix(m); ok || m.Grouped && m != e {
+				break
+			}
+			messages = append(messages, m.Message)
+			root = m.Receiver
+		}
+		if len(messages) == 0 {
+			return line
+		}
+		sb.WriteString(prefix + receiver(root))
+		for i := len(messages) - 1; i >= 0; i-- {
+			sb.WriteString("\n" + indent + operand(messages[i], simplePrecedence))
+		}
+	default:
+		return line
+	}
+	return sb.String()
+}
+
+// infix recognizes sends desugared from infix operators, returning the operands and the operator.
+func infix(e *MessageExpr) (Expr, string, Expr, bool) {
+	if !e.Infix {
+		return nil, "", nil, false
+	}
+	m, ok := e.Receiver.(*MessageExpr)
+	if !ok {
+		return nil, "", nil, false
+	}
+	op, ok := m.Message.(*SymbolExpr)
+	if !ok || Precedence(op.Symbol) == 0 {
+		return nil, "", nil, false
+	}
+	return m.Receiver, op.Symbol, e.Message, true
+}
+
+func exprPrecedence(e Expr) int {
+	switch e := e.(type) {
+	case *PipeExpr, *LetExpr:
+		return pipePrecedence
+	case *MessageExpr:
+		if _, op, _, ok := infix(e); ok {
+			return Precedence(op)
+		}
+		return applicationPrecedence
+	default:
+		return simplePrecedence
+	}
+}
+
+// operand formats e, adding parentheses unless it binds at least as tightly as minPrecedence.
+func operand(e Expr, minPrecedence int) string {
+	if exprPrecedence(e) < minPrecedence {
+		return "(" + format(e) + ")"
+	}
+	return format(e)
+}
+
+// receiver formats the receiver of a send, keeping the parentheses of grouped sends.
+func receiver(e Expr) string {
+	if m, ok := e.(*MessageExpr); ok && m.Grouped && !m.Infix {
+		return "(" + format(m) + ")"
+	}
+	return operand(e, applicationPrecedence)
+}
+
+func format(e Expr) string {
+	switch e := e.(type) {
+	case nil:
+		return ""
+	case *RefExpr:
+		return e.Ref
+	case *SymbolExpr:
+		return QuoteSymbol(e.Symbol)
+	case *NullExpr:
+		return "null"
+	case *BoolExpr:
+		return fmt.Sprintf("%v", e.Bool)
+	case *NumExpr:
+		return cl.FormatNum(e.Number)
+	case *StringExpr:
+		return `"` + escapeString(e.String) + `"`
+	case *InterpolatedStringExpr:
+		var sb strings.Builder
+		sb.WriteString(`"`)
+		for _, part := range e.Parts {
+			if s, ok := part.(*StringExpr); ok {
+				sb.WriteString(escapeString(s.String))
+			} else {
+				sb.WriteString("${" + format(part) + "}")
+			}
+		}
+		sb.WriteString(`"`)
+		return sb.String()
+	case *LambdaBlockExpr:
+		if len(e.Symbols) == 0 {
+			return "[" + format(e.Body) + "]"
+		}
+		return "[" + strings.Join(e.Symbols, " ") + " | " + format(e.Body) + "]"
+	case *LetExpr:
+		return "let " + e.Ref + " = " + format(e.Value) + " | " + format(e.Body)
+	case *PipeExpr:
+		return format(e.Left) + " |> " + operand(e.Right, pipePrecedence+1)
+	case *MessageExpr:
+		if lhs, op, rhs, ok := infix(e); ok {
+			p := Precedence(op)
+			return operand(lhs, p) + " " + op + " " + operand(rhs, p+1)
+		}
+		return receiver(e.Receiver) + " " + operand(e.Message, simplePrecedence)
+	default:
+		panic(fmt.Sprintf("format is incomplete, got %#T", e))
+	}
+}
+
+// escapeString escapes text for a string literal, including the start of embedded expressions.
+func escapeString(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '$':
+			if strings.HasPrefix(s[i:], "${") {
+				sb.WriteString(`\$`)
+			} else {
+				sb.WriteRune(r)
+			}
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Dump renders an Expr, Stmt or Query as an indented tree with one node per line, for debugging.
+// Source locations are left out, so that equivalent trees have equal dumps.
+func Dump(node any) string {
+	var sb strings.Builder
+	dump(&sb, 0, node)
+	return sb.String()
+}
+
+func dump(sb *strings.Builder, depth int, node any) {
+	line := func(format string, args ...any) {
+		sb.WriteString(strings.Repeat("  ", depth))
+		fmt.Fprintf(sb, format, args...)
+		sb.WriteString("\n")
+	}
+	switch node := node.(type) {
+	case nil:
+		line("nil")
+	case *ExprStmt:
+		line("ExprStmt")
+		dump(sb, depth+1, node.Expr)
+	case *AssignStmt:
+		line("AssignStmt %s", node.Ref)
+		dump(sb, depth+1, node.Expr)
+	case *DefStmt:
+		line("DefStmt %s [%s] %q", node.Ref, strings.Join(node.Params, " "), node.Doc)
+		dump(sb, depth+1, node.Body)
+	case *SymbolQuery:
+		line("SymbolQuery %q", node.Symbol)
+		dump(sb, depth+1, node.Expr)
+	case *RefQuery:
+		line("RefQuery %s", node.Ref)
+	case *RefExpr:
+		line("RefExpr %s", node.Ref)
+	case *SymbolExpr:
+		line("SymbolExpr %q", node.Symbol)
+	case *NullExpr:
+		line("NullExpr")
+	case *BoolExpr:
+		line("BoolExpr %v", node.Bool)
+	case *NumExpr:
+		line("NumExpr %s", node.Number.RatString())
+	case *StringExpr:
+		line("StringExpr %q", node.String)
+	case *InterpolatedStringExpr:
+		line("InterpolatedStringExpr")
+		for _, part := range node.Parts {
+			dump(sb, depth+1, part)
+		}
+	case *LambdaBlockExpr:
+		line("LambdaBlockExpr [%s]", strings.Join(node.Symbols, " "))
+		dump(sb, depth+1, node.Body)
+	case *LetExpr:
+		line("LetExpr %s", node.Ref)
+		dump(sb, depth+1, node.Value)
+		dump(sb, depth+1, node.Body)
+	case *PipeExpr:
+		line("PipeExpr")
+		dump(sb, depth+1, node.Left)
+		dump(sb, depth+1, node.Right)
+	case *MessageExpr:
+		switch {
+		case node.Infix:
+			line("MessageExpr infix")
+		case node.Grouped:
+			line("MessageExpr grouped")
+		default:
+			line("MessageExpr")
+		}
+		dump(sb, depth+1, node.Receiver)
+		dump(sb, depth+1, node.Message)
+	default:
+		panic(fmt.Sprintf("Dump is incomplete, got %#T", node))
+	}
+}
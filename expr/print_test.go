@@ -0,0 +1,63 @@
+package expr
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	sum := &MessageExpr{
+		Receiver: &MessageExpr{Receiver: &NumExpr{Number: big.NewRat(1, 2)}, Message: &SymbolExpr{Symbol: "+"}},
+		Message:  &RefExpr{Ref: "$x"},
+		Infix:    true,
+	}
+	send := &MessageExpr{Receiver: sum, Message: &SymbolExpr{Symbol: "a b"}}
+	assert.Equal(t, "(0.5 + $x) `a b`", String(send))
+	assert.Equal(t, "$y = 0.5 + $x", String(&AssignStmt{Ref: "$y", Expr: sum}))
+	assert.Equal(t, "$m (0.5 + $x) na", String(&SymbolQuery{
+		Expr:   &MessageExpr{Receiver: &RefExpr{Ref: "$m"}, Message: sum},
+		Symbol: "na",
+	}))
+	assert.Equal(t, "$m ", String(&SymbolQuery{Expr: &RefExpr{Ref: "$m"}}))
+	assert.Equal(t, "$fo", String(&RefQuery{Ref: "$fo"}))
+	assert.Equal(t, `[$s | "${$s}\n"]`, String(&LambdaBlockExpr{
+		Symbols: []string{"$s"},
+		Body:    &InterpolatedStringExpr{Parts: []Expr{&RefExpr{Ref: "$s"}, &StringExpr{String: "\n"}}},
+	}))
+}
+
+func TestFormat(t *testing.T) {
+	chain := Expr(&RefExpr{Ref: "$x"})
+	for _, m := range []string{"first", "second", "third"} {
+		chain = &MessageExpr{Receiver: chain, Message: &SymbolExpr{Symbol: m}}
+	}
+	assert.Equal(t, "$x first second third", Format(chain, 30))
+	assert.Equal(t, "$x\n    first\n    second\n    third", Format(chain, 10))
+	pipe := &PipeExpr{Left: &PipeExpr{Left: chain, Right: &RefExpr{Ref: "$f"}}, Right: &RefExpr{Ref: "$g"}}
+	assert.Equal(t, "$x first second third\n    |> $f\n    |> $g", Format(pipe, 10))
+}
+
+func TestDump(t *testing.T) {
+	stmt := &ExprStmt{Expr: &PipeExpr{
+		Left: &MessageExpr{Receiver: &RefExpr{Ref: "$x", Offset: 3}, Message: &StringExpr{String: "s"}},
+		Right: &LambdaBlockExpr{
+			Symbols: []string{"$y"},
+		},
+	}}
+	assert.Equal(t, `ExprStmt
+  PipeExpr
+    MessageExpr
+      RefExpr $x
+      StringExpr "s"
+    LambdaBlockExpr [$y]
+      nil
+`, Dump(stmt))
+}
+
+func TestDumpDef(t *testing.T) {
+	stmt := &DefStmt{Ref: "$f", Params: []string{"$x", "$y"}, Doc: "Doc.", Body: &RefExpr{Ref: "$x"}}
+	assert.Equal(t, "DefStmt $f [$x $y] \"Doc.\"\n  RefExpr $x\n", Dump(stmt))
+	assert.Equal(t, `def $f $x $y "Doc." = $x`, String(stmt))
+}
@@ -0,0 +1,46 @@
+package expr
+
+import (
+	"strings"
+	"unicode"
+)
+
+// IsSymbolStart checks if r can start a bare symbol.
+func IsSymbolStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// IsSymbolChar checks if r can continue a bare symbol or a ref.
+func IsSymbolChar(r rune) bool {
+	switch r {
+	case '_', '-', ':', '/', '.':
+		return true
+	default:
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+	}
+}
+
+// IsBareSymbol checks if s can be written as a symbol without quotes. The keywords null, true and
+// false are not bare symbols.
+func IsBareSymbol(s string) bool {
+	switch s {
+	case "", "null", "true", "false":
+		return false
+	}
+	for i, r := range s {
+		if i == 0 && !IsSymbolStart(r) || !IsSymbolChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// QuoteSymbol writes s as source code for a symbol, quoting it with backticks unless it is a bare
+// symbol.
+func QuoteSymbol(s string) string {
+	if IsBareSymbol(s) {
+		return s
+	}
+	r := strings.NewReplacer("\\", "\\\\", "`", "\\`")
+	return "`" + r.Replace(s) + "`"
+}
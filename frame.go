@@ -0,0 +1,96 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultMaxDepth limits the nesting of closure calls unless a context sets another limit with
+// WithMaxDepth. It leaves ample room on the stack of a goroutine.
+const DefaultMaxDepth = 10000
+
+type frameKey struct{}
+
+// frame is the context of a closure call. It keeps the state of the call in one context rather than a
+// context per value, and caches the done channel of its parent, so that checking for cancellation
+// does not walk the contexts of every enclosing call.
+type frame struct {
+	context.Context
+	calls  int
+	max    int
+	source string
+	done   <-chan struct{}
+}
+
+func (f *frame) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *frame) Value(key any) any {
+	if key == (frameKey{}) {
+		return f
+	}
+	return f.Context.Value(key)
+}
+
+func frameOf(ctx context.Context) frame {
+	if f, ok := ctx.Value(frameKey{}).(*frame); ok {
+		return *f
+	}
+	return frame{max: DefaultMaxDepth}
+}
+
+func withFrame(ctx context.Context, f frame) context.Context {
+	f.Context, f.done = ctx, ctx.Done()
+	return &f
+}
+
+// WithMaxDepth returns a context in which calling closures nested more than max deep fails with an
+// error with MaxDepthCode, instead of exhausting the stack and crashing the process. Calls in tail
+// position, such as the branches of then and else, do not count as nested.
+func WithMaxDepth(ctx context.Context, max int) context.Context {
+	f := frameOf(ctx)
+	f.max = max
+	return withFrame(ctx, f)
+}
+
+// WithSource returns a context for evaluating code parsed from source, so that the spans of the errors
+// it produces refer to it. Closures with a Source evaluate their calls in their own source instead.
+func WithSource(ctx context.Context, source string) context.Context {
+	f := frameOf(ctx)
+	f.source = source
+	return withFrame(ctx, f)
+}
+
+// SourceOf returns the source set with WithSource, or the empty string.
+func SourceOf(ctx context.Context) string {
+	return frameOf(ctx).source
+}
+
+// enterCall returns the context for a call of c nested in the calls of ctx, or an error if that
+// exceeds the maximum depth.
+func enterCall(ctx context.Context, c Closure) (context.Context, Value) {
+	f := frameOf(ctx)
+	if f.calls >= f.max {
+		return ctx, Error{
+			ErrorMessage: fmt.Sprintf("maximum call depth of %d exceeded", f.max),
+			Code:         MaxDepthCode,
+		}
+	}
+	f.calls++
+	if c.Source != "" {
+		f.source = c.Source
+	}
+	return withFrame(ctx, f), nil
+}
+
+// cancelled returns an error with CancelledCode if ctx is done. Contexts of closure calls answer
+// Done without walking their parents, which keeps this cheap enough to check on every step.
+func cancelled(ctx context.Context) Value {
+	select {
+	case <-ctx.Done():
+		return Error{ErrorMessage: "evaluation cancelled", Code: CancelledCode, Err: ctx.Err()}
+	default:
+		return nil
+	}
+}
@@ -26,3 +26,65 @@ type deferredValue func() Value
 func (d deferredValue) Message(ctx context.Context, msg Value) Value {
 	return d().Message(ctx, msg)
 }
+
+// Undefer resolves lazy and deferred values. Unlike Force, it does not call closures.
+func Undefer(v Value) Value {
+	for {
+		d, ok := v.(deferredValue)
+		if !ok {
+			return v
+		}
+		v = d()
+	}
+}
+
+// Tail marks v as the result of a function in tail position, such as the branch taken by a
+// conditional, which is still to be forced. Rather than forcing v itself, which would nest a call on
+// the stack for every step of a recursion, the function returns it to the loop in Force or Run that
+// is evaluating its caller. Messages other than :run force the value and forward the message.
+func Tail(v Value) Value {
+	return tailValue{v}
+}
+
+type tailValue struct {
+	value Value
+}
+
+func (t tailValue) Message(ctx context.Context, msg Value) Value {
+	if _, ok := msg.(RunMessage); ok {
+		return Run(ctx, t.value)
+	}
+	return Force(ctx, t.value).Message(ctx, msg)
+}
+
+// ForceTail forces values made by Tail and leaves other values as they are. Evaluators call it on
+// values that are not in tail position, such as arguments, before passing them on.
+func ForceTail(ctx context.Context, v Value) Value {
+	if t, ok := v.(tailValue); ok {
+		return Force(ctx, t.value)
+	}
+	return v
+}
+
+// step takes one step of evaluation that is free of side-effects, resolving deferred values, tail
+// values and conditionals and calling saturated transparent closures. It returns false if there is no
+// such step to take. Once ctx is done, the step is to an error with CancelledCode, so that the loops
+// in Run, Force and Plan stop even when the evaluation would never end.
+func step(ctx context.Context, v Value) (Value, bool) {
+	if err := cancelled(ctx); err != nil && !IsError(v) {
+		return err, true
+	}
+	switch x := v.(type) {
+	case deferredValue:
+		return x(), true
+	case tailValue:
+		return x.value, true
+	case conditional:
+		return x.result(), true
+	case Closure:
+		if len(x.Params) == 0 && x.Transparent && !x.IsPure {
+			return x.call(ctx), true
+		}
+	}
+	return v, false
+}
@@ -0,0 +1,168 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Messages understood by NumValue. Arithmetic and comparisons take the other operand as an
+// argument, for example `$x "+" 1`. Numbers are exact rationals, so only division by zero fails.
+//
+// Formatting is available with `$x fixed 2`, which rounds to the given number of decimal places,
+// and `$x float`, which shows the closest float64.
+var numMessages = []string{
+	"!=", "*", "+", "-", "/", "<", "<=", "==", ">", ">=", "fixed", "float", "mod",
+}
+
+// NewNum makes a NumValue from an int64.
+func NewNum(n int64) NumValue {
+	return NumValue{new(big.Rat).SetInt64(n)}
+}
+
+func (x NumValue) respond(ctx context.Context, message string) (Value, bool) {
+	arith := func(f func(z, a, b *big.Rat) Value) Value {
+		return builtin(message, "y", func(ctx context.Context, arg Value) Value {
+			y, err := toNum(ctx, arg)
+			if err != nil {
+				return err
+			}
+			return f(new(big.Rat), x.Num, y.Num)
+		})
+	}
+	compare := func(f func(c int) bool) Value {
+		return builtin(message, "y", func(ctx context.Context, arg Value) Value {
+			y, err := toNum(ctx, arg)
+			if err != nil {
+				return err
+			}
+			return BoolValue{f(x.Num.Cmp(y.Num))}
+		})
+	}
+	switch message {
+	case "+":
+		return arith(func(z, a, b *big.Rat) Value { return NumValue{z.Add(a, b)} }), true
+	case "-":
+		return arith(func(z, a, b *big.Rat) Value { return NumValue{z.Sub(a, b)} }), true
+	case "*":
+		return arith(func(z, a, b *big.Rat) Value { return NumValue{z.Mul(a, b)} }), true
+	case "/":
+		return arith(func(z, a, b *big.Rat) Value {
+			if b.Sign() == 0 {
+				return divisionByZero(a)
+			}
+			return NumValue{z.Quo(a, b)}
+		}), true
+	case "mod":
+		return arith(func(z, a, b *big.Rat) Value {
+			if b.Sign() == 0 {
+				return divisionByZero(a)
+			}
+			return NumValue{mod(a, b)}
+		}), true
+	case "==", "!=":
+		return equality(message, func(ctx context.Context, arg Value) bool {
+			y, ok := arg.(NumValue)
+			return ok && x.Num.Cmp(y.Num) == 0
+		}), true
+	case "<":
+		return compare(func(c int) bool { return c < 0 }), true
+	case "<=":
+		return compare(func(c int) bool { return c <= 0 }), true
+	case ">":
+		return compare(func(c int) bool { return c > 0 }), true
+	case ">=":
+		return compare(func(c int) bool { return c >= 0 }), true
+	case "fixed":
+		return builtin(message, "y", func(ctx context.Context, arg Value) Value {
+			y, err := toNum(ctx, arg)
+			if err != nil {
+				return err
+			}
+			if !y.Num.IsInt() || y.Num.Sign() < 0 || !y.Num.Num().IsInt64() {
+				return Error{
+					ErrorMessage: fmt.Sprintf("expected a number of decimal places, got %s",
+						describeValue(ctx, y)),
+					Code: TypeErrorCode,
+				}
+			}
+			return StringValue{x.Num.FloatString(int(y.Num.Num().Int64()))}
+		}), true
+	case "float":
+		f, _ := x.Num.Float64()
+		return StringValue{strconv.FormatFloat(f, 'g', -1, 64)}, true
+	default:
+		return nil, false
+	}
+}
+
+// mod returns the remainder of flooring division, which has the sign of the divisor.
+func mod(a, b *big.Rat) *big.Rat {
+	q := new(big.Rat).Quo(a, b)
+	floor := new(big.Int).Div(q.Num(), q.Denom())
+	r := new(big.Rat).Mul(b, new(big.Rat).SetInt(floor))
+	return r.Sub(a, r)
+}
+
+func divisionByZero(a *big.Rat) Value {
+	return Error{
+		ErrorMessage: fmt.Sprintf("division of %s by zero", FormatNum(a)),
+		Code:         DivisionByZeroCode,
+	}
+}
+
+// toNum forces v to a number, returning the error value to propagate if it is not one.
+func toNum(ctx context.Context, v Value) (NumValue, Value) {
+	v = Force(ctx, v)
+	if n, ok := v.(NumValue); ok {
+		return n, nil
+	}
+	if IsError(v) {
+		return NumValue{}, v
+	}
+	return NumValue{}, Error{
+		ErrorMessage: fmt.Sprintf("expected a number, got %s", describeValue(ctx, v)),
+		Code:         TypeErrorCode,
+	}
+}
+
+// FormatNum shows integers and numbers with a finite decimal expansion exactly in decimal notation
+// and other rationals as a fraction, such as 1/3.
+func FormatNum(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	d := new(big.Int).Set(r.Denom())
+	places := 0
+	two, five := big.NewInt(2), big.NewInt(5)
+	m := new(big.Int)
+	for _, p := range []*big.Int{two, five} {
+		n := 0
+		for {
+			q, rem := new(big.Int).QuoRem(d, p, m)
+			if rem.Sign() != 0 {
+				break
+			}
+			d = q
+			n++
+		}
+		if n > places {
+			places = n
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return r.RatString()
+	}
+	return r.FloatString(places)
+}
+
+// ParseNum parses a number as written in complang source or shown by :show: a decimal with an
+// optional fraction and exponent, or a fraction such as 1/3.
+func ParseNum(s string) (NumValue, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return NumValue{}, fmt.Errorf("invalid number: %q", s)
+	}
+	return NumValue{r}, nil
+}
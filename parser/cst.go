@@ -0,0 +1,228 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CST is a lossless concrete syntax tree. Unlike the expressions returned by ParseExpr it keeps
+// trivia, that is whitespace and comments, so that tools can rewrite source code without losing
+// the layout: String reproduces the parsed source exactly.
+type CST struct {
+	Nodes []*Node
+	// Trivia after the last node.
+	Trivia string
+}
+
+// Node is a token, or a group of nodes enclosed in parentheses or square brackets.
+type Node struct {
+	// Trivia preceding the node.
+	Trivia string
+	Kind   TokenKind
+	// Source code of the token, or the opening bracket of a group.
+	Text string
+	// Character-based offset of Text in the source code.
+	Offset int
+	// Nodes inside a group.
+	Children []*Node
+	// Closing bracket of a group. It is nil for tokens and for groups left open at the end of input.
+	Close *Node
+}
+
+// IsGroup checks if the node is a parenthesized expression or a lambda block.
+func (n *Node) IsGroup() bool {
+	return n.Text == "(" || n.Text == "["
+}
+
+// ParseCST parses source code into a CST. Only lexical errors are reported: unbalanced brackets are
+// kept as unterminated groups or stray tokens.
+func ParseCST(code string) (*CST, error) {
+	source := []rune(code)
+	tokens, err := lex(source, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	nodes, pos, _ := buildNodes(source, tokens, 0, "")
+	return &CST{Nodes: nodes, Trivia: string(source[pos:])}, nil
+}
+
+// buildNodes makes nodes of tokens up to the closing bracket close, if any. It returns the nodes, the
+// end of the last token consumed and the remaining tokens.
+func buildNodes(source []rune, tokens []token, pos int, close string) ([]*Node, int, []token) {
+	nodes := []*Node{}
+	for len(tokens) > 0 {
+		t := tokens[0]
+		if _, ok := t.t.(comment); ok {
+			tokens = tokens[1:]
+			continue
+		}
+		n := &Node{
+			Trivia: string(source[pos:t.offset]),
+			Kind:   tokenKind(t),
+			Text:   string(source[t.offset : t.offset+t.length]),
+			Offset: t.offset,
+		}
+		if n.Text == close {
+			return nodes, pos, tokens
+		}
+		pos = t.offset + t.length
+		tokens = tokens[1:]
+		switch {
+		case n.Text == ")" || n.Text == "]":
+			// A stray closing bracket that does not close any open group.
+		case n.IsGroup():
+			closeText := ")"
+			if n.Text == "[" {
+				closeText = "]"
+			}
+			n.Children, pos, tokens = buildNodes(source, tokens, pos, closeText)
+			if len(tokens) > 0 {
+				c := tokens[0]
+				n.Close = &Node{
+					Trivia: string(source[pos:c.offset]),
+					Kind:   BracketToken,
+					Text:   closeText,
+					Offset: c.offset,
+				}
+				pos = c.offset + c.length
+				tokens = tokens[1:]
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, pos, tokens
+}
+
+// String renders the tree back to source code.
+func (c *CST) String() string {
+	var sb strings.Builder
+	writeNodes(&sb, c.Nodes)
+	sb.WriteString(c.Trivia)
+	return sb.String()
+}
+
+func writeNodes(sb *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		sb.WriteString(n.Trivia)
+		sb.WriteString(n.Text)
+		writeNodes(sb, n.Children)
+		if n.Close != nil {
+			sb.WriteString(n.Close.Trivia)
+			sb.WriteString(n.Close.Text)
+		}
+	}
+}
+
+// Comments returns the text of the comments in the tree, in source order.
+func (c *CST) Comments() []string {
+	comments := []string{}
+	var walk func(trivia string)
+	walk = func(trivia string) {
+		for _, line := range strings.Split(trivia, "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				comments = append(comments, line[i:])
+			}
+		}
+	}
+	var visit func(nodes []*Node)
+	visit = func(nodes []*Node) {
+		for _, n := range nodes {
+			walk(n.Trivia)
+			visit(n.Children)
+			if n.Close != nil {
+				walk(n.Close.Trivia)
+			}
+		}
+	}
+	visit(c.Nodes)
+	walk(c.Trivia)
+	return comments
+}
+
+// RenameRef renames the free occurrences of the ref from to the ref to, leaving refs bound by lambda
+// block parameters of the same name alone. Refs in the expressions embedded in strings are renamed
+// too. It returns the number of occurrences renamed.
+func (c *CST) RenameRef(from, to string) (int, error) {
+	if !isRef(symbol(from)) || !isRef(symbol(to)) {
+		return 0, fmt.Errorf("expected refs, got %q and %q", from, to)
+	}
+	return renameNodes(c.Nodes, from, to)
+}
+
+func renameNodes(nodes []*Node, from, to string) (int, error) {
+	count := 0
+	for _, n := range nodes {
+		switch {
+		case n.Kind == RefToken && n.Text == from:
+			n.Text = to
+			count++
+		case n.Kind == StringToken && strings.Contains(n.Text, "${"):
+			k, err := renameInString(n, from, to)
+			if err != nil {
+				return count, err
+			}
+			count += k
+		case n.Text == "[" && binds(n.Children, from):
+			// The lambda block shadows the ref.
+		case n.IsGroup():
+			k, err := renameNodes(n.Children, from, to)
+			if err != nil {
+				return count, err
+			}
+			count += k
+		}
+	}
+	return count, nil
+}
+
+// binds checks if the contents of a lambda block declare a parameter named ref, as in [$x | ...].
+func binds(children []*Node, ref string) bool {
+	found := false
+	for _, n := range children {
+		switch {
+		case n.Text == "|":
+			return found
+		case n.Kind == RefToken || n.Kind == SymbolToken:
+			found = found || n.Text == ref
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// renameInString renames refs in the expressions embedded in an interpolated string literal.
+func renameInString(n *Node, from, to string) (int, error) {
+	source := []rune(n.Text)
+	tokens, err := tokenize(n.Text)
+	if err != nil {
+		return 0, err
+	}
+	parts, ok := tokens[0].t.(interpolation)
+	if !ok {
+		return 0, nil
+	}
+	var sb strings.Builder
+	count, pos := 0, 0
+	for _, part := range parts {
+		embedded, ok := part.([]token)
+		if !ok || len(embedded) == 0 {
+			continue
+		}
+		start := embedded[0].offset
+		last := embedded[len(embedded)-1]
+		end := last.offset + last.length
+		nodes, _, _ := buildNodes(source, embedded, start, "")
+		k, err := renameNodes(nodes, from, to)
+		if err != nil {
+			return count, err
+		}
+		count += k
+		sb.WriteString(string(source[pos:start]))
+		writeNodes(&sb, nodes)
+		pos = end
+	}
+	sb.WriteString(string(source[pos:]))
+	n.Text = sb.String()
+	return count, nil
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t0yv0/complang/expr"
+)
+
+func TestCSTRoundTrip(t *testing.T) {
+	for _, code := range []string{
+		"",
+		"  # only a comment\n",
+		"$x = $obj  field # trailing\n",
+		"[$x |\n  # inside\n  $x f]   (a  b)\n",
+		"$x |> [$y | \"${ $y  name }!\"] ",
+		"unbalanced ( [ ) ]] `quoted sym` 1.5e3",
+	} {
+		cst, err := ParseCST(code)
+		assert.NoError(t, err)
+		assert.Equal(t, code, cst.String())
+	}
+}
+
+func TestCSTComments(t *testing.T) {
+	cst, err := ParseCST("# one\n$x # two\n[$y # three\n]")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"# one", "# two", "# three"}, cst.Comments())
+
+	s, err := ParseStmt("$x # the rest is ignored ]")
+	assert.NoError(t, err)
+	assert.Equal(t, "$x", s.(*expr.ExprStmt).Expr.(*expr.RefExpr).Ref)
+}
+
+func TestCSTRenameRef(t *testing.T) {
+	for code, expected := range map[string]string{
+		"$x f  # keep $x in comments": "$y f  # keep $x in comments",
+		"[$x | $x f] $x":              "[$x | $x f] $y",
+		"[$z | $x ($x g)]":            "[$z | $y ($y g)]",
+		"[$x f]":                      "[$y f]",
+		`"a ${ $x } b ${[$x | $x]}"`:  `"a ${ $y } b ${[$x | $x]}"`,
+		"$xx $x":                      "$xx $y",
+	} {
+		cst, err := ParseCST(code)
+		assert.NoError(t, err)
+		_, err = cst.RenameRef("$x", "$y")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, cst.String(), code)
+	}
+	cst, err := ParseCST("$x")
+	assert.NoError(t, err)
+	_, err = cst.RenameRef("$x", "y")
+	assert.Error(t, err)
+}
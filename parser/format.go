@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/t0yv0/complang/expr"
+)
+
+// Statements longer than maxWidth characters are wrapped.
+const maxWidth = 100
+
+// Format renders an expression as canonical source code. Long pipelines and message chains are
+// wrapped onto indented continuation lines.
+func Format(e expr.Expr) string {
+	return expr.Format(e, maxWidth)
+}
+
+// FormatStmt renders a statement as canonical source code, like Format.
+func FormatStmt(s expr.Stmt) string {
+	return expr.Format(s, maxWidth)
+}
+
+// FormatSource formats a script of statements, one per line, keeping comments. Statements may
+// continue on following lines that are indented. Comments on their own lines are kept in place and
+// a comment after a statement stays at the end of its last line. Runs of blank lines are collapsed to
+// one.
+//
+// Comments inside a statement, such as after a pipeline stage on a continuation line, stay after the
+// token they followed, and the statement continues on the next line.
+func FormatSource(code string) (string, error) {
+	cst, err := ParseCST(code)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	blank := false
+	emit := func(line string) {
+		if line == "" {
+			blank = out.Len() > 0
+			return
+		}
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+		out.WriteString(line + "\n")
+	}
+	// trivia emits comment lines and blank lines found in the trivia between statements.
+	trivia := func(text string) {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			line = strings.TrimSpace(line)
+			if line != "" || i < len(lines)-1 {
+				emit(line)
+			}
+		}
+	}
+	stmts := splitStatements(cst)
+	for i, stmt := range stmts {
+		if i == 0 {
+			trivia(stmt[0].Trivia)
+		}
+		var next string
+		if i+1 < len(stmts) {
+			next = stmts[i+1][0].Trivia
+		} else {
+			next = cst.Trivia
+		}
+		// A comment before the first newline after the statement stays at the end of its line.
+		trailing, rest, _ := strings.Cut(next, "\n")
+		trailing = strings.TrimSpace(trailing)
+
+		var sb strings.Builder
+		for j, n := range stmt {
+			if j > 0 {
+				sb.WriteString(n.Trivia)
+			}
+			writeNodes(&sb, []*Node{{Text: n.Text, Children: n.Children, Close: n.Close}})
+		}
+		s, err := ParseStmt(sb.String())
+		if err != nil || s == nil {
+			return "", fmt.Errorf("line %d: could not parse statement: %s", line(code, stmt[0].Offset),
+				strings.TrimSpace(sb.String()))
+		}
+		formatted, above := placeComments(sb.String(), FormatStmt(s))
+		for _, c := range above {
+			emit(c)
+		}
+		if trailing != "" {
+			formatted += "  " + trailing
+		}
+		for _, l := range strings.Split(formatted, "\n") {
+			emit(l)
+		}
+		if strings.Contains(next, "\n") {
+			trivia(rest)
+		}
+	}
+	if len(stmts) == 0 {
+		trivia(cst.Trivia)
+	}
+	return out.String(), nil
+}
+
+// EscapeInterpolation migrates a script written before string interpolation existed, when "${" in a
+// string literal was plain text, by escaping it as "\${". Comments and quoted symbols are left alone.
+func EscapeInterpolation(code string) string {
+	var sb strings.Builder
+	input := []rune(code)
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '#':
+			for ; i < len(input) && input[i] != '\n'; i++ {
+				sb.WriteRune(input[i])
+			}
+			if i < len(input) {
+				sb.WriteRune(input[i])
+			}
+		case '`':
+			sb.WriteRune(input[i])
+			for i++; i < len(input) && input[i] != '`'; i++ {
+				if input[i] == '\\' && i+1 < len(input) && (input[i+1] == '`' || input[i+1] == '\\') {
+					sb.WriteRune(input[i])
+					i++
+				}
+				sb.WriteRune(input[i])
+			}
+			if i < len(input) {
+				sb.WriteRune(input[i])
+			}
+		case '"':
+			sb.WriteRune(input[i])
+			for i++; i < len(input) && input[i] != '"'; i++ {
+				switch {
+				case input[i] == '\\' && i+1 < len(input):
+					sb.WriteRune(input[i])
+					i++
+				case input[i] == '$' && i+1 < len(input) && input[i+1] == '{':
+					sb.WriteRune('\\')
+				}
+				sb.WriteRune(input[i])
+			}
+			if i < len(input) {
+				sb.WriteRune(input[i])
+			}
+		default:
+			sb.WriteRune(input[i])
+		}
+	}
+	return sb.String()
+}
+
+// splitStatements groups the top-level nodes of a script into statements. A node starts a new
+// statement when it begins a line without indentation.
+func splitStatements(cst *CST) [][]*Node {
+	stmts := [][]*Node{}
+	for i, n := range cst.Nodes {
+		lines := strings.Split(n.Trivia, "\n")
+		if i == 0 || len(lines) > 1 && lines[len(lines)-1] == "" {
+			stmts = append(stmts, []*Node{})
+		}
+		stmts[len(stmts)-1] = append(stmts[len(stmts)-1], n)
+	}
+	return stmts
+}
+
+// innerComment is a comment inside a statement, attached to the token before it.
+type innerComment struct {
+	text string
+	// after is the index of the token, not counting parentheses, which the printer may add or drop.
+	after int
+	// ownLine is set for comments on a line of their own, rather than at the end of a line of code.
+	ownLine bool
+}
+
+// placeComments inserts the comments of the statement source into formatted, the statement as the
+// printer lays it out. Each comment follows its token, together with any closing brackets right after
+// it, and the statement continues on an indented line. Comments that cannot be placed, because they
+// precede the first token or the tokens do not line up, are returned to be emitted above the
+// statement instead.
+func placeComments(source, formatted string) (string, []string) {
+	tokens, _ := lex([]rune(source), 0, false)
+	var comments []innerComment
+	count := 0
+	for _, t := range tokens {
+		switch t.t.(type) {
+		case comment:
+			lineStart := strings.LastIndex(string([]rune(source)[:t.offset]), "\n") + 1
+			before := string([]rune(source)[:t.offset])[lineStart:]
+			comments = append(comments, innerComment{
+				text:    string(t.t.(comment)),
+				after:   count - 1,
+				ownLine: strings.TrimSpace(before) == "",
+			})
+		default:
+			if !isParen(t) {
+				count++
+			}
+		}
+	}
+	if len(comments) == 0 {
+		return formatted, nil
+	}
+	out, _ := lex([]rune(formatted), 0, false)
+	var positions []int // indexes in out of the tokens that are not parentheses
+	for i, t := range out {
+		if !isParen(t) {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) != count || comments[0].after < 0 {
+		var above []string
+		for _, c := range comments {
+			above = append(above, c.text)
+		}
+		return formatted, above
+	}
+	const indent = "    "
+	runes := []rune(formatted)
+	var sb strings.Builder
+	pos := 0
+	for i := 0; i < len(comments); {
+		j := positions[comments[i].after]
+		for j+1 < len(out) && (out[j+1].t == byte(')') || out[j+1].t == byte(']')) {
+			j++
+		}
+		end := out[j].offset + out[j].length
+		sb.WriteString(string(runes[pos:end]))
+		for first := true; i < len(comments) && positions[comments[i].after] <= j; i, first = i+1, false {
+			if first && !comments[i].ownLine {
+				sb.WriteString("  " + comments[i].text)
+			} else {
+				sb.WriteString("\n" + indent + comments[i].text)
+			}
+		}
+		pos = end
+		if j+1 < len(out) {
+			sb.WriteString("\n" + indent)
+			pos = out[j+1].offset
+		}
+	}
+	sb.WriteString(string(runes[pos:]))
+	return sb.String(), nil
+}
+
+func isParen(t token) bool {
+	return t.t == byte('(') || t.t == byte(')')
+}
+
+// line returns the 1-based line number of a character offset.
+func line(code string, offset int) int {
+	return strings.Count(string([]rune(code)[:offset]), "\n") + 1
+}
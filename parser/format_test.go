@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	for code, expected := range map[string]string{
+		"$x   f  g":               "$x f g",
+		"$x (f g)":                "$x (f g)",
+		"($x f) g":                "($x f) g",
+		"1+2 * 3":                 "1 + 2 * 3",
+		"(1 + 2) * 3":             "(1 + 2) * 3",
+		"1 - (2 - 3)":             "1 - (2 - 3)",
+		"(1 - 2) - 3":             "1 - 2 - 3",
+		"$x f + 1":                "$x f + 1",
+		"($x + 1) f":              "($x + 1) f",
+		"$x  |>  f |> [$y|$y  g]": "$x |> f |> [$y | $y g]",
+		"$a = [ ]":                "$a = []",
+		"[$x $y|$x]":              "[$x $y | $x]",
+		"`a b` 1.50 -2 null true": "`a b` 1.5 -2 null true",
+		`"aA\n${ $x   f }\${"`:    `"aA\n${$x f}\${"`,
+	} {
+		s, err := ParseStmt(code)
+		require.NoError(t, err, code)
+		assert.Equal(t, expected, FormatStmt(s), code)
+	}
+}
+
+func TestFormatWrapsLongLines(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	s, err := ParseStmt("$x |> " + long + " |> " + long + " |> " + long)
+	require.NoError(t, err)
+	assert.Equal(t, "$x\n    |> "+long+"\n    |> "+long+"\n    |> "+long, FormatStmt(s))
+
+	s, err = ParseStmt("$x " + long + " " + long + " (" + long + " b)")
+	require.NoError(t, err)
+	assert.Equal(t, "$x\n    "+long+"\n    "+long+"\n    ("+long+" b)", FormatStmt(s))
+}
+
+func TestFormatSource(t *testing.T) {
+	code := `# header
+
+
+$x   =  1+2  # three
+$y = $x
+  |> f   # inside
+  |> g
+
+
+
+# footer
+`
+	expected := `# header
+
+$x = 1 + 2  # three
+$y = $x |> f  # inside
+    |> g
+
+# footer
+`
+	formatted, err := FormatSource(code)
+	require.NoError(t, err)
+	assert.Equal(t, expected, formatted)
+
+	again, err := FormatSource(formatted)
+	require.NoError(t, err)
+	assert.Equal(t, formatted, again)
+
+	for code, expected := range map[string]string{
+		"$x f\n  # own line\n  g\n":   "$x f\n    # own line\n    g\n",
+		"$x |> [$y | $y  # y\n  f]\n": "$x |> [$y | $y  # y\n    f]\n",
+		"$x (f  # f\n  g)\n":          "$x (f  # f\n    g)\n",
+		"$x (f g)  # end\n":           "$x (f g)  # end\n",
+		"$x (f  # a\n  # b\n  g) h\n": "$x (f  # a\n    # b\n    g) h\n",
+		"$x [f  # f\n  ] g\n":         "$x [f]  # f\n    g\n",
+	} {
+		formatted, err := FormatSource(code)
+		require.NoError(t, err, code)
+		assert.Equal(t, expected, formatted, code)
+		again, err := FormatSource(formatted)
+		require.NoError(t, err, code)
+		assert.Equal(t, formatted, again, code)
+	}
+
+	_, err = FormatSource("$x\n$y = \n")
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestEscapeInterpolation(t *testing.T) {
+	for code, expected := range map[string]string{
+		`"cost: ${"`:           `"cost: \${"`,
+		`"a\"${" "\${" "${}"`:  `"a\"\${" "\${" "\${}"`,
+		"`${` # \"${\n\"${\"":  "`${` # \"${\n\"\\${\"",
+		"`a\\`${` \"b\"":       "`a\\`${` \"b\"",
+		`$x |> [$y | "${$y}"]`: `$x |> [$y | "\${$y}"]`,
+		`"unterminated ${`:     `"unterminated \${`,
+	} {
+		assert.Equal(t, expected, EscapeInterpolation(code), code)
+	}
+	formatted, err := FormatSource(EscapeInterpolation("$x = \"cost: ${\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "$x = \"cost: \\${\"\n", formatted)
+}
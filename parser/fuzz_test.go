@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/t0yv0/complang/expr"
+)
+
+var fuzzSeeds = []string{
+	"",
+	"$x",
+	"$x = $y name",
+	"$x f (g h) [$y | $y]",
+	"1 + 2 * -3.5e2 <= 4",
+	`"a ${$x "${ b }"} é😀"`,
+	"`quoted \\` sym` # comment",
+	"$x |> f |> [$y | $y]",
+	"[[$x | $x]",
+	"$x (",
+	"-",
+	"1e",
+	"1.",
+	"\"${",
+	"$m `key",
+	"$x |> ",
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, code string) {
+		tokens, err := tokenize(code)
+		if err != nil {
+			return
+		}
+		n := utf8.RuneCountInString(code)
+		for _, tok := range tokens {
+			if tok.offset < 0 || tok.length <= 0 || tok.offset+tok.length > n {
+				t.Fatalf("token %#v out of bounds in %q", tok, code)
+			}
+		}
+		Tokens(code)
+		if _, err := ParseCST(code); err != nil {
+			t.Fatalf("ParseCST failed on %q: %v", code, err)
+		}
+	})
+}
+
+func FuzzParseStmt(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, code string) {
+		s, err := ParseStmt(code)
+		if err != nil || s == nil {
+			return
+		}
+		printed := expr.String(s)
+		if _, err := ParseStmt(printed); err != nil {
+			t.Fatalf("could not parse %q printed from %q: %v", printed, code, err)
+		}
+		expr.Dump(s)
+		FormatSource(code)
+	})
+}
+
+func FuzzParseQuery(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, code string) {
+		q, err := ParseQuery(code)
+		if err != nil {
+			return
+		}
+		if q.Offset() < 0 || q.Offset() > utf8.RuneCountInString(code) {
+			t.Fatalf("query offset %d out of bounds in %q", q.Offset(), code)
+		}
+		expr.String(q)
+	})
+}
@@ -0,0 +1,73 @@
+package parser
+
+// TokenKind classifies lexemes for syntax highlighting.
+type TokenKind int
+
+const (
+	SymbolToken TokenKind = iota
+	RefToken
+	StringToken
+	NumberToken
+	BoolToken
+	NullToken
+	// Brackets and parentheses.
+	BracketToken
+	// Other punctuation such as '=' and '|'.
+	PunctuationToken
+	// Infix operators such as '+' and '<='.
+	OperatorToken
+	// Line comments starting with '#'.
+	CommentToken
+)
+
+// Token describes the kind and the source position of a lexeme.
+type Token struct {
+	Kind   TokenKind
+	Offset int
+	Length int
+}
+
+// Tokens lexes code for syntax highlighting. Unlike the parser it is lenient: lexing stops at the
+// first error and the tokens recognized up to that point are returned.
+func Tokens(code string) []Token {
+	tokens, _ := lex([]rune(code), 0, false)
+	result := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, Token{
+			Kind:   tokenKind(t),
+			Offset: t.offset,
+			Length: t.length,
+		})
+	}
+	return result
+}
+
+func tokenKind(t token) TokenKind {
+	switch v := t.t.(type) {
+	case symbol:
+		if isRef(v) {
+			return RefToken
+		}
+		return SymbolToken
+	case quotedSymbol:
+		return SymbolToken
+	case string, interpolation:
+		return StringToken
+	case number:
+		return NumberToken
+	case operator:
+		return OperatorToken
+	case comment:
+		return CommentToken
+	case bool:
+		return BoolToken
+	case nil:
+		return NullToken
+	case byte:
+		switch v {
+		case '(', ')', '[', ']':
+			return BracketToken
+		}
+	}
+	return PunctuationToken
+}
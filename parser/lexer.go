@@ -1,14 +1,45 @@
 package parser
 
 import (
-	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/t0yv0/complang/expr"
 )
 
+// tokenize splits source code into tokens. Token offsets and lengths count runes rather than bytes.
+// On error it also returns the tokens lexed so far.
 func tokenize(s string) ([]token, error) {
-	var buf bytes.Buffer
+	return tokenizeRunes([]rune(s), 0, false)
+}
+
+// tokenizeRunes lexes s without comments, adding base to the offsets of the tokens. When partial is
+// set, a quoted symbol may be left unterminated at the end of the input, as happens while it is
+// being completed.
+func tokenizeRunes(s []rune, base int, partial bool) ([]token, error) {
+	tokens, err := lex(s, base, partial)
+	result := tokens[:0]
+	for _, t := range tokens {
+		if _, ok := t.t.(comment); !ok {
+			result = append(result, t)
+		}
+	}
+	return result, err
+}
+
+// lex is like tokenizeRunes but also returns comment tokens.
+func lex(s []rune, base int, partial bool) ([]token, error) {
+	var buf strings.Builder
 	tokens := []token{}
 	i := 0
+	add := func(tok token) {
+		tok.length = i - tok.offset
+		tok.offset += base
+		tokens = append(tokens, tok)
+	}
 	for {
 		if i >= len(s) {
 			return tokens, nil
@@ -16,113 +47,217 @@ func tokenize(s string) ([]token, error) {
 		switch s[i] {
 		case ' ', '\t', '\r', '\n':
 			i++
-		case '(', ')', '=', '[', ']', '|':
-			tokens = append(tokens, token{
-				t:      s[i],
-				offset: i,
-				length: 1,
-			})
+		case '#':
+			tok := token{offset: i}
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			tok.t = comment(s[tok.offset:i])
+			add(tok)
+		case '|':
+			tok := token{t: byte('|'), offset: i}
+			i++
+			if i < len(s) && s[i] == '>' {
+				tok.t = pipe
+				i++
+			}
+			add(tok)
+		case '(', ')', '[', ']':
+			tok := token{t: byte(s[i]), offset: i}
 			i++
+			add(tok)
 		case '$':
 			tok := token{offset: i}
-			buf.Reset()
-			buf.WriteByte('$')
 			i++
-			for i < len(s) && symchar(s[i]) {
-				buf.WriteByte(s[i])
+			for i < len(s) && expr.IsSymbolChar(s[i]) {
 				i++
 			}
-			s := buf.String()
-			buf.Reset()
-			tok.length = i - tok.offset
-			tok.t = symbol(s)
-			tokens = append(tokens, tok)
+			tok.t = symbol(s[tok.offset:i])
+			add(tok)
+		case '`':
+			tok := token{offset: i}
+			sym, ok := lexQuotedSymbol(&buf, s, &i)
+			if !ok && !partial {
+				return tokens, fmt.Errorf("unexpected end of input in quoted symbol")
+			}
+			tok.t = sym
+			add(tok)
 		case '"':
 			tok := token{offset: i}
-			s, err := lexString(&buf, s, &i)
+			str, err := lexString(&buf, s, &i, base)
 			if err != nil {
-				return nil, err
+				return tokens, err
 			}
-			tok.t = s
-			tok.length = i - tok.offset
-			tokens = append(tokens, tok)
-		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			tok.t = str
+			add(tok)
+		case '+', '*', '/', '<', '>', '!', '=':
 			tok := token{offset: i}
-			n, err := lexNumber(s, &i)
-			if err != nil {
-				return nil, err
+			op, ok := lexOperator(s, &i)
+			if !ok {
+				return tokens, fmt.Errorf("unexpected '%v'", string(s[i]))
+			}
+			tok.t = op
+			add(tok)
+		case '-':
+			tok := token{offset: i}
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				n, ok := lexNumber(s, &i)
+				if !ok {
+					return tokens, fmt.Errorf("number out of range: %s", n)
+				}
+				tok.t = n
+			} else {
+				tok.t = operator("-")
+				i++
+			}
+			add(tok)
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			tok := token{offset: i}
+			n, ok := lexNumber(s, &i)
+			if !ok {
+				return tokens, fmt.Errorf("number out of range: %s", n)
 			}
 			tok.t = n
-			tok.length = i - tok.offset
-			tokens = append(tokens, tok)
+			add(tok)
 		default:
-			if symstarter(s[i]) {
-				tok := token{offset: i}
-				buf.Reset()
-				buf.WriteByte(s[i])
+			if !expr.IsSymbolStart(s[i]) {
+				return tokens, fmt.Errorf("unexpected '%v'", string(s[i]))
+			}
+			tok := token{offset: i}
+			i++
+			for i < len(s) && expr.IsSymbolChar(s[i]) {
 				i++
-				for i < len(s) && symchar(s[i]) {
-					buf.WriteByte(s[i])
-					i++
-				}
-				s := buf.String()
-				buf.Reset()
-				switch s {
-				case "null":
-					tok.t = nil
-				case "true":
-					tok.t = true
-				case "false":
-					tok.t = false
-				default:
-					tok.t = symbol(s)
-				}
-				tok.length = i - tok.offset
-				tokens = append(tokens, tok)
-			} else {
-				return nil, fmt.Errorf("unexpected '%v'", string(s[i]))
 			}
+			switch word := string(s[tok.offset:i]); word {
+			case "null":
+				tok.t = nil
+			case "true":
+				tok.t = true
+			case "false":
+				tok.t = false
+			default:
+				tok.t = symbol(word)
+			}
+			add(tok)
 		}
 	}
 }
 
-func lexNumber(input string, pos *int) (int, error) {
-	s := 1
-	n := 0
-	for *pos < len(input) {
-		switch input[*pos] {
-		case '-':
-			s = -1
-			*pos = *pos + 1
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-			d := int(input[*pos]) - int('0')
-			n = 10*n + d
-			*pos = *pos + 1
-		default:
-			return s * n, nil
+// lexOperator lexes an infix operator. A single '=' is not an operator but the punctuation of
+// assignment statements.
+func lexOperator(input []rune, pos *int) (any, bool) {
+	i := *pos
+	if i+1 < len(input) && input[i+1] == '=' {
+		switch input[i] {
+		case '=', '!', '<', '>':
+			*pos = i + 2
+			return operator(input[i : i+2]), true
 		}
 	}
-	return s * n, nil
+	switch input[i] {
+	case '=':
+		*pos = i + 1
+		return byte('='), true
+	case '!':
+		return nil, false
+	default:
+		*pos = i + 1
+		return operator(input[i : i+1]), true
+	}
 }
 
-func lexString(buf *bytes.Buffer, input string, pos *int) (string, error) {
+// maxExponent bounds the exponent of number literals. Numbers are exact, so a literal such as 1e999999
+// would take a million digits.
+const maxExponent = 1000
+
+// lexNumber lexes a decimal number with an optional sign, fraction and exponent, such as -1.5e3.
+// The input must start with a digit, or a '-' followed by a digit. It returns false if the exponent
+// exceeds maxExponent.
+func lexNumber(input []rune, pos *int) (number, bool) {
+	start := *pos
+	i := *pos
+	digits := func() int {
+		n := 0
+		for i < len(input) && isDigit(input[i]) {
+			i++
+			n++
+		}
+		return n
+	}
+	if input[i] == '-' {
+		i++
+	}
+	digits()
+	if i+1 < len(input) && input[i] == '.' && isDigit(input[i+1]) {
+		i++
+		digits()
+	}
+	inRange := true
+	if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+		j := i
+		i++
+		if i < len(input) && (input[i] == '+' || input[i] == '-') {
+			i++
+		}
+		k := i
+		if digits() == 0 {
+			i = j
+		} else if exp, err := strconv.Atoi(string(input[k:i])); err != nil || exp > maxExponent {
+			inRange = false
+		}
+	}
+	*pos = i
+	return number(input[start:i]), inRange
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// lexQuotedSymbol lexes a symbol quoted with backticks, such as `key with spaces`. Backticks and
+// backslashes are escaped with a backslash. It returns false if the input ends before the closing
+// backtick.
+func lexQuotedSymbol(buf *strings.Builder, input []rune, pos *int) (quotedSymbol, bool) {
+	buf.Reset()
+	i := *pos + 1
+	defer func() { *pos = i }()
+	for i < len(input) {
+		switch input[i] {
+		case '`':
+			i++
+			return quotedSymbol(buf.String()), true
+		case '\\':
+			if i+1 < len(input) && (input[i+1] == '`' || input[i+1] == '\\') {
+				i++
+			}
+		}
+		buf.WriteRune(input[i])
+		i++
+	}
+	return quotedSymbol(buf.String()), false
+}
+
+// lexString lexes a string literal following the JSON grammar. Literals that embed expressions, as
+// in "host: ${$h name}", are returned as an interpolation rather than a string.
+func lexString(buf *strings.Builder, input []rune, pos *int, base int) (any, error) {
 	i := *pos
 	i++
 	buf.Reset()
+	var parts []any
 	for {
 		if i >= len(input) {
 			return "", fmt.Errorf("unexpected end of input in string literal")
 		}
-		switch input[i] {
-		case '\\':
+		switch r := input[i]; {
+		case r == '\\':
 			i++
 			if i >= len(input) {
 				return "", fmt.Errorf("unexpected end of input in string literal")
 			}
 			switch input[i] {
-			case '"':
+			case '"', '\\', '/', '$':
+				buf.WriteRune(input[i])
 				i++
-				buf.WriteByte('"')
 			case 'b':
 				i++
 				buf.WriteByte('\b')
@@ -138,49 +273,123 @@ func lexString(buf *bytes.Buffer, input string, pos *int) (string, error) {
 			case 't':
 				i++
 				buf.WriteByte('\t')
+			case 'u':
+				r, err := lexUnicodeEscape(input, &i)
+				if err != nil {
+					return "", err
+				}
+				buf.WriteRune(r)
 			default:
 				return "", fmt.Errorf("invalid string escape")
 			}
-		case '"':
+		case r == '$' && i+1 < len(input) && input[i+1] == '{':
+			start := i + 2
+			end, err := scanEmbedded(input, start)
+			if err != nil {
+				return "", err
+			}
+			tokens, err := tokenizeRunes(input[start:end], base+start, false)
+			if err != nil {
+				return "", err
+			}
+			if buf.Len() > 0 {
+				parts = append(parts, buf.String())
+				buf.Reset()
+			}
+			parts = append(parts, tokens)
+			i = end + 1
+		case r == '"':
 			i++
 			s := buf.String()
 			buf.Reset()
 			*pos = i
-			return s, nil
+			if parts == nil {
+				return s, nil
+			}
+			if s != "" {
+				parts = append(parts, s)
+			}
+			return interpolation(parts), nil
+		case r < 0x20:
+			return "", fmt.Errorf("invalid control character in string literal")
 		default:
-			buf.WriteByte(input[i])
+			buf.WriteRune(r)
 			i++
 		}
 	}
 }
 
-func symstarter(c byte) bool {
-	switch {
-	case c == '_':
-		return true
-	case c >= 'a' && c <= 'z':
-		return true
-	case c >= 'A' && c <= 'Z':
-		return true
-	default:
-		return false
+// lexUnicodeEscape decodes \uXXXX at input[*pos], which points at the 'u', combining UTF-16
+// surrogate pairs. Unpaired surrogates decode to the replacement character, as in encoding/json.
+func lexUnicodeEscape(input []rune, pos *int) (rune, error) {
+	hex := func(i int) (rune, bool) {
+		if i+4 > len(input) {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(string(input[i:i+4]), 16, 16)
+		return rune(n), err == nil
 	}
+	r, ok := hex(*pos + 1)
+	if !ok {
+		return 0, fmt.Errorf("invalid unicode escape in string literal")
+	}
+	*pos += 5
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+	i := *pos
+	if i+1 < len(input) && input[i] == '\\' && input[i+1] == 'u' {
+		if r2, ok := hex(i + 2); ok {
+			if dec := utf16.DecodeRune(r, r2); dec != unicode.ReplacementChar {
+				*pos = i + 6
+				return dec, nil
+			}
+		}
+	}
+	return unicode.ReplacementChar, nil
 }
 
-func symchar(c byte) bool {
-	switch c {
-	case '_', '-', ':', '/', '.':
-		return true
-	default:
-		switch {
-		case c >= '0' && c <= '9':
-			return true
-		case c >= 'a' && c <= 'z':
-			return true
-		case c >= 'A' && c <= 'Z':
-			return true
+// scanEmbedded finds the closing brace of an expression embedded in a string literal, skipping over
+// nested string literals and quoted symbols. Nested literals are only skipped rather than lexed, since
+// the embedded expression is lexed afterwards; lexing them here as well would take time exponential
+// in the depth of nesting.
+func scanEmbedded(input []rune, i int) (int, error) {
+	for i < len(input) {
+		switch input[i] {
+		case '}':
+			return i, nil
+		case '`':
+			if _, ok := lexQuotedSymbol(new(strings.Builder), input, &i); !ok {
+				return 0, fmt.Errorf("unexpected end of input in quoted symbol")
+			}
+		case '"':
+			end, err := skipString(input, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
 		default:
-			return false
+			i++
+		}
+	}
+	return 0, fmt.Errorf("unexpected end of input in string interpolation")
+}
+
+// skipString returns the end of the string literal starting at input[i].
+func skipString(input []rune, i int) (int, error) {
+	for i++; i < len(input); i++ {
+		switch {
+		case input[i] == '\\':
+			i++
+		case input[i] == '"':
+			return i + 1, nil
+		case input[i] == '$' && i+1 < len(input) && input[i+1] == '{':
+			end, err := scanEmbedded(input, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = end
 		}
 	}
+	return 0, fmt.Errorf("unexpected end of input in string literal")
 }
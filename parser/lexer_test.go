@@ -1,10 +1,13 @@
 package parser
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/t0yv0/complang/expr"
 )
 
 func TestTokenize(t *testing.T) {
@@ -63,12 +66,44 @@ func TestTokenize(t *testing.T) {
 		},
 		{
 			s:      "123",
-			tokens: []any{123},
+			tokens: []any{number("123")},
+		},
+		{
+			s:      "-1.5e3 2e",
+			tokens: []any{number("-1.5e3"), number("2"), symbol("e")},
 		},
 		{
 			s:      "foo:bar/baz",
 			tokens: []any{symbol("foo:bar/baz")},
 		},
+		{
+			s:      `"a\\b\/c\u00e9\ud83d\ude00"`,
+			tokens: []any{"a\\b/cé😀"},
+		},
+		{
+			s:      `"\ud83d" "\ude00x"`,
+			tokens: []any{"\ufffd", "\ufffdx"},
+		},
+		{
+			s:   `"\u00"`,
+			err: errors.New("invalid unicode escape in string literal"),
+		},
+		{
+			s:      "$café über_straße",
+			tokens: []any{symbol("$café"), symbol("über_straße")},
+		},
+		{
+			s:   "\"tab\there\"",
+			err: errors.New("invalid control character in string literal"),
+		},
+		{
+			s:      "1e1000 -2.5E-1000",
+			tokens: []any{number("1e1000"), number("-2.5E-1000")},
+		},
+		{
+			s:   "1e1001",
+			err: errors.New("number out of range: 1e1001"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -104,3 +139,64 @@ func TestOffsets(t *testing.T) {
 	assert.Equal(t, symbol("subf"), tokens[len(tokens)-1].t)
 	assert.Equal(t, "subf", source[tokens[len(tokens)-1].offset:])
 }
+
+func TestRuneOffsets(t *testing.T) {
+	source := `$café "ü" naïve`
+	tokens, err := tokenize(source)
+	assert.NoError(t, err)
+	last := tokens[len(tokens)-1]
+	assert.Equal(t, 10, last.offset)
+	assert.Equal(t, 5, last.length)
+	assert.Equal(t, "naïve", string([]rune(source)[last.offset:]))
+}
+
+func TestTokens(t *testing.T) {
+	source := `$x = [$y | $y f "s" 1 true null] "unterminated`
+	kinds := []TokenKind{}
+	for _, tok := range Tokens(source) {
+		kinds = append(kinds, tok.Kind)
+	}
+	assert.Equal(t, []TokenKind{
+		RefToken, PunctuationToken, BracketToken, RefToken, PunctuationToken, RefToken,
+		SymbolToken, StringToken, NumberToken, BoolToken, NullToken, BracketToken,
+	}, kinds)
+}
+
+func TestQuotedSymbols(t *testing.T) {
+	for _, s := range []string{"plain", "with space", "@home", "#tag", "1st", "$ref", "null", "",
+		"back`tick", `back\slash`, "é"} {
+		tokens, err := tokenize(expr.QuoteSymbol(s))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(tokens))
+		switch tok := tokens[0].t.(type) {
+		case symbol:
+			assert.True(t, expr.IsBareSymbol(s))
+			assert.Equal(t, s, string(tok))
+		case quotedSymbol:
+			assert.False(t, expr.IsBareSymbol(s))
+			assert.Equal(t, s, string(tok))
+		default:
+			t.Errorf("unexpected token %v for %q", tok, s)
+		}
+	}
+	_, err := tokenize("`unterminated")
+	assert.Error(t, err)
+}
+
+func TestEscapedInterpolation(t *testing.T) {
+	// "${" used to be plain text; it now starts an interpolation and needs escaping.
+	_, err := tokenize(`"cost: ${"`)
+	assert.Error(t, err)
+	tokens, err := tokenize(`"cost: \${"`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tokens))
+	assert.Equal(t, "cost: ${", tokens[0].t)
+}
+
+func TestNestedInterpolation(t *testing.T) {
+	// Each level used to be lexed twice, taking time exponential in the depth.
+	source := strings.Repeat(`"${`, 40) + "1" + strings.Repeat(`}"`, 40)
+	tokens, err := tokenize(source)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tokens))
+}
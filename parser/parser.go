@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/t0yv0/complang/expr"
 )
@@ -17,6 +18,12 @@ func ParseExpr(code string) (expr.Expr, error) {
 	if e == nil && len(rest) > 0 {
 		return nil, fmt.Errorf("could not parse expression")
 	}
+	if len(rest) > 0 {
+		return nil, unexpected(code, rest[0])
+	}
+	if unclosed(tokens) {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
 	return e, nil
 }
 
@@ -29,25 +36,199 @@ func ParseStmt(code string) (expr.Stmt, error) {
 	if e == nil && len(rest) > 0 {
 		return nil, fmt.Errorf("could not parse expression")
 	}
+	if len(rest) > 0 {
+		return nil, unexpected(code, rest[0])
+	}
+	if unclosed(tokens) {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
 	return e, nil
 }
 
+// unclosed checks for parentheses left open at the end of the input. The parser accepts them so that
+// queries can complete inside parentheses, but complete expressions and statements must close them.
+func unclosed(tokens []token) bool {
+	depth := 0
+	for _, t := range tokens {
+		switch t.t {
+		case byte('('):
+			depth++
+		case byte(')'):
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// unexpected reports a token left over after parsing, such as an unbalanced closing bracket.
+func unexpected(code string, t token) error {
+	return fmt.Errorf("unexpected '%s'", string([]rune(code)[t.offset:t.offset+t.length]))
+}
+
 func ParseQuery(code string) (expr.Query, error) {
-	tokens, err := tokenize(code)
+	// Tolerate an unterminated quoted symbol that is being completed.
+	tokens, err := tokenizeRunes([]rune(code), 0, true)
 	if err != nil {
 		return nil, err
 	}
-	if strings.HasSuffix(code, " ") {
-		return parseEmptySymbolQuery(code, tokens)
+	// Messages to the value piped into a stage being typed are completed by the type of that value.
+	if lets, body, ok := openStages(tokens); ok {
+		switch q, _ := parseQueryTokens(code, body); q := q.(type) {
+		case *expr.SymbolQuery:
+			q.Expr = withLets(lets, q.Expr)
+			return q, nil
+		case *expr.RefQuery:
+			q.Locals = localRefs(tokens[:len(tokens)-1])
+			return q, nil
+		}
+	}
+	return parseQueryTokens(code, tokens)
+}
+
+func parseQueryTokens(code string, tokens []token) (expr.Query, error) {
+	// A trailing space starts a new symbol, unless it is inside a quoted symbol.
+	n := utf8.RuneCountInString(code)
+	inToken := len(tokens) > 0 && tokens[len(tokens)-1].offset+tokens[len(tokens)-1].length == n
+	if strings.HasSuffix(code, " ") && !inToken {
+		q, err := parseEmptySymbolQuery(code, tokens)
+		if value, ok := openLet(tokens); err != nil && ok {
+			return parseEmptySymbolQuery(code, value)
+		}
+		return q, err
 	}
 	e, rest := parseQuery(tokens)
 	if e == nil || len(rest) > 0 {
+		if value, ok := openLet(tokens); ok {
+			if e, rest := parseQuery(value); e != nil && len(rest) == 0 {
+				return e, nil
+			}
+		}
 		return nil, fmt.Errorf("could not parse expression in query")
 	}
 	return e, nil
 }
 
+// openStages finds the lambda blocks that are being typed as stages of pipelines, as in
+// `$x |> [$d | $d na`. A stage is applied to the value piped into it, so its last parameter is bound
+// to that value. openStages returns these bindings as let expressions, outermost first, together with
+// the tokens of the body of the innermost stage typed so far.
+func openStages(tokens []token) ([]*expr.LetExpr, []token, bool) {
+	type open struct {
+		start int // index of the first token inside the bracket, after the parameters of a lambda
+		stage *expr.LetExpr
+	}
+	stack := []open{{}}
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].t {
+		case byte('('):
+			stack = append(stack, open{start: i + 1})
+		case byte('['):
+			o := open{start: i + 1}
+			if params, rest := parseLambdaBlockParams(tokens[i+1:]); len(params) > 0 {
+				o.start = len(tokens) - len(rest)
+				if i > 0 && tokens[i-1].t == pipe {
+					value, ok := pipedValue(tokens[stack[len(stack)-1].start : i-1])
+					if !ok {
+						return nil, nil, false
+					}
+					o.stage = &expr.LetExpr{Ref: params[len(params)-1], Value: value}
+				}
+			}
+			stack = append(stack, o)
+		case byte(')'), byte(']'):
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	var lets []*expr.LetExpr
+	var body []token
+	for _, o := range stack {
+		if o.stage != nil {
+			lets, body = append(lets, o.stage), tokens[o.start:]
+		}
+	}
+	return lets, body, len(lets) > 0
+}
+
+// pipedValue parses the tokens before a |> into the expression whose value is piped.
+func pipedValue(tokens []token) (expr.Expr, bool) {
+	stmt, rest := parseStmt(tokens)
+	if stmt == nil || len(rest) > 0 {
+		return nil, false
+	}
+	return queryExpr(stmt), true
+}
+
+// queryExpr is the expression of a statement that completion sends messages to.
+func queryExpr(stmt expr.Stmt) expr.Expr {
+	switch stmt := stmt.(type) {
+	case *expr.AssignStmt:
+		return stmt.Expr
+	case *expr.DefStmt:
+		return stmt.Body
+	default:
+		return stmt.(*expr.ExprStmt).Expr
+	}
+}
+
+// openLet finds the value of a let expression that is still being typed, as in `let $x = $m na`, so
+// that it can be completed before the '|' is typed.
+func openLet(tokens []token) ([]token, bool) {
+	for i := len(tokens) - 3; i >= 0; i-- {
+		if ref, ok := tokens[i+1].t.(symbol); tokens[i].t != symbol("let") || !ok || !isRef(ref) ||
+			tokens[i+2].t != byte('=') {
+			continue
+		}
+		for _, t := range tokens[i+3:] {
+			if t.t == byte('|') {
+				return nil, false
+			}
+		}
+		return tokens[i+3:], true
+	}
+	return nil, false
+}
+
 func parseExpr(tokens []token) (expr.Expr, []token) {
+	return parseInfixExpr(tokens, 1)
+}
+
+// parseInfixExpr parses operator applications with at least the given precedence by precedence
+// climbing, desugaring `a + b` to `(a +) b`.
+func parseInfixExpr(tokens []token, minPrecedence int) (expr.Expr, []token) {
+	e, tokens := parseApplicationExpr(tokens)
+	if e == nil {
+		return nil, tokens
+	}
+	for len(tokens) > 0 {
+		op, ok := tokens[0].t.(operator)
+		if !ok || expr.Precedence(string(op)) < minPrecedence {
+			break
+		}
+		rhs, rest := parseInfixExpr(tokens[1:], expr.Precedence(string(op))+1)
+		if rhs == nil {
+			break
+		}
+		e = &expr.MessageExpr{
+			Receiver: &expr.MessageExpr{
+				Receiver: e,
+				Message: &expr.SymbolExpr{
+					Symbol: string(op),
+					Offset: tokens[0].offset,
+					Length: tokens[0].length,
+				},
+			},
+			Message: rhs,
+			Infix:   true,
+		}
+		tokens = rest
+	}
+	return e, tokens
+}
+
+func parseApplicationExpr(tokens []token) (expr.Expr, []token) {
+	parenthesized := len(tokens) > 0 && tokens[0].t == byte('(')
 	e, tokens := parseSimpleExpr(tokens)
 	if e == nil {
 		return nil, tokens
@@ -58,6 +239,10 @@ func parseExpr(tokens []token) (expr.Expr, []token) {
 		if subE == nil {
 			return e, tokens
 		} else {
+			if m, ok := e.(*expr.MessageExpr); ok && parenthesized && !m.Infix {
+				m.Grouped = true
+			}
+			parenthesized = false
 			e = &expr.MessageExpr{Receiver: e, Message: subE}
 		}
 	}
@@ -68,7 +253,12 @@ func parseSimpleExpr(tokens []token) (expr.Expr, []token) {
 		return nil, tokens
 	}
 	if tokens[0].t == byte('(') {
+		// The closing parenthesis is optional so that queries can complete inside parentheses;
+		// ParseExpr and ParseStmt reject it missing with unclosed.
 		e, rest := parseExpr(tokens[1:])
+		if e == nil {
+			return nil, tokens
+		}
 		if len(rest) > 0 && rest[0].t == byte(')') {
 			rest = rest[1:]
 		}
@@ -77,40 +267,71 @@ func parseSimpleExpr(tokens []token) (expr.Expr, []token) {
 	if tokens[0].t == byte('[') {
 		return parseLambdaBlockExpr(tokens)
 	}
+	offset, length := tokens[0].offset, tokens[0].length
 	switch t := tokens[0].t.(type) {
 	case symbol:
+		if t == "let" {
+			if e, rest := parseLetExpr(tokens); e != nil {
+				return e, rest
+			}
+		}
 		if isRef(t) {
 			return &expr.RefExpr{
 				Ref:    string(t),
-				Offset: tokens[0].offset,
+				Offset: offset,
+				Length: length,
 			}, tokens[1:]
 		}
 		return &expr.SymbolExpr{
 			Symbol: string(t),
-			Offset: tokens[0].offset,
+			Offset: offset,
+			Length: length,
 		}, tokens[1:]
+	case quotedSymbol:
+		return &expr.SymbolExpr{Symbol: string(t), Offset: offset, Length: length}, tokens[1:]
 	case string:
-		return &expr.StringExpr{String: t}, tokens[1:]
-	case int:
-		return &expr.NumExpr{Number: big.NewFloat(float64(t))}, tokens[1:]
+		return &expr.StringExpr{String: t, Offset: offset, Length: length}, tokens[1:]
+	case interpolation:
+		parts := []expr.Expr{}
+		for _, part := range t {
+			switch part := part.(type) {
+			case string:
+				parts = append(parts, &expr.StringExpr{String: part})
+			case []token:
+				e, rest := parseExpr(part)
+				if e == nil || len(rest) > 0 {
+					return nil, tokens
+				}
+				parts = append(parts, e)
+			}
+		}
+		return &expr.InterpolatedStringExpr{Parts: parts, Offset: offset, Length: length}, tokens[1:]
+	case number:
+		n, ok := new(big.Rat).SetString(string(t))
+		if !ok {
+			return nil, tokens
+		}
+		return &expr.NumExpr{Number: n, Offset: offset, Length: length}, tokens[1:]
 	case bool:
-		return &expr.BoolExpr{Bool: t}, tokens[1:]
+		return &expr.BoolExpr{Bool: t, Offset: offset, Length: length}, tokens[1:]
 	case nil:
-		return &expr.NullExpr{}, tokens[1:]
+		return &expr.NullExpr{Offset: offset, Length: length}, tokens[1:]
 	default:
 		return nil, tokens
 	}
 }
 
+// parseLambdaBlockParams parses the parameters of a lambda block up to the '|'. Blocks without
+// parameters start with any other token, such as the '[' of a nested block in `[[$x | $x]]`.
 func parseLambdaBlockParams(tokens []token) ([]string, []token) {
 	var params []string
 	for i := 0; i < len(tokens); i++ {
 		if s, isSymbol := tokens[i].t.(symbol); isSymbol {
 			params = append(params, string(s))
-		} else if tokens[i].t == byte(']') {
-			return nil, tokens
 		} else if tokens[i].t == byte('|') {
 			return params, tokens[i+1:]
+		} else {
+			return nil, tokens
 		}
 	}
 	return nil, tokens
@@ -134,9 +355,39 @@ func parseLambdaBlockExpr(tokens []token) (expr.Expr, []token) {
 	return &expr.LambdaBlockExpr{
 		Symbols: symbols,
 		Body:    body,
+		Offset:  tokens[0].offset,
+		Length:  rest2[0].offset + rest2[0].length - tokens[0].offset,
 	}, rest2[1:]
 }
 
+// parseLetExpr parses `let $x = value | body`. The word let is only a keyword when followed by a ref
+// and '=', so it can still be sent as a symbol, as in `$m let`.
+func parseLetExpr(tokens []token) (expr.Expr, []token) {
+	if len(tokens) < 3 || tokens[2].t != byte('=') {
+		return nil, tokens
+	}
+	ref, ok := tokens[1].t.(symbol)
+	if !ok || !isRef(ref) {
+		return nil, tokens
+	}
+	value, rest := parseExpr(tokens[3:])
+	if value == nil || len(rest) == 0 || rest[0].t != byte('|') {
+		return nil, tokens
+	}
+	body, rest := parseExpr(rest[1:])
+	if body == nil {
+		return nil, tokens
+	}
+	end := expr.Span(body)
+	return &expr.LetExpr{
+		Ref:    string(ref),
+		Value:  value,
+		Body:   body,
+		Offset: tokens[0].offset,
+		Length: end.Offset + end.Length - tokens[0].offset,
+	}, rest
+}
+
 func isRef(s symbol) bool {
 	return strings.HasPrefix(string(s), "$")
 }
@@ -145,9 +396,12 @@ func parseStmt(tokens []token) (expr.Stmt, []token) {
 	if len(tokens) == 0 {
 		return nil, tokens
 	}
+	if d, rest := parseDefStmt(tokens); d != nil {
+		return d, rest
+	}
 	if s, ok := tokens[0].t.(symbol); ok && isRef(s) {
 		if len(tokens) > 1 && tokens[1].t == byte('=') {
-			e, rest := parseExpr(tokens[2:])
+			e, rest := parsePipeline(tokens[2:])
 			if e != nil {
 				return &expr.AssignStmt{
 					Ref:  string(s),
@@ -156,7 +410,7 @@ func parseStmt(tokens []token) (expr.Stmt, []token) {
 			}
 		}
 	}
-	e, rest := parseExpr(tokens)
+	e, rest := parsePipeline(tokens)
 	if e != nil {
 		return &expr.ExprStmt{
 			Expr: e,
@@ -165,6 +419,59 @@ func parseStmt(tokens []token) (expr.Stmt, []token) {
 	return nil, tokens
 }
 
+// parseDefStmt parses `def $f $x $y "doc" = body`. As with let, the word def is only a keyword when
+// followed by a ref.
+func parseDefStmt(tokens []token) (*expr.DefStmt, []token) {
+	if len(tokens) < 2 || tokens[0].t != symbol("def") {
+		return nil, tokens
+	}
+	ref, ok := tokens[1].t.(symbol)
+	if !ok || !isRef(ref) {
+		return nil, tokens
+	}
+	d := &expr.DefStmt{Ref: string(ref)}
+	rest := tokens[2:]
+	for len(rest) > 0 {
+		param, ok := rest[0].t.(symbol)
+		if !ok || !isRef(param) {
+			break
+		}
+		d.Params = append(d.Params, string(param))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		if doc, ok := rest[0].t.(string); ok {
+			d.Doc = doc
+			rest = rest[1:]
+		}
+	}
+	if len(rest) == 0 || rest[0].t != byte('=') {
+		return nil, tokens
+	}
+	d.Body, rest = parsePipeline(rest[1:])
+	if d.Body == nil {
+		return nil, tokens
+	}
+	return d, rest
+}
+
+// parsePipeline parses expressions separated by |>, which associates to the left.
+func parsePipeline(tokens []token) (expr.Expr, []token) {
+	e, tokens := parseExpr(tokens)
+	if e == nil {
+		return nil, tokens
+	}
+	for len(tokens) > 1 && tokens[0].t == pipe {
+		right, rest := parseExpr(tokens[1:])
+		if right == nil {
+			break
+		}
+		e = &expr.PipeExpr{Left: e, Right: right, Offset: tokens[0].offset}
+		tokens = rest
+	}
+	return e, tokens
+}
+
 func parseQuery(tokens []token) (expr.Query, []token) {
 	if e, rest := parseSymbolQuery(tokens); e != nil {
 		return e, rest
@@ -180,29 +487,81 @@ func parseRefQuery(tokens []token) (expr.Query, []token) {
 			return &expr.RefQuery{
 				Ref:       re.Ref,
 				RefOffset: re.Offset,
+				Locals:    localRefs(tokens[:len(tokens)-1]),
 			}, nil
 		}
 	}
 	return nil, tokens
 }
 
+// localRefs finds the refs that are in scope after tokens without being bound in the environment:
+// the name and parameters of a def whose body is being typed, the parameters of unclosed lambda
+// blocks and the refs of let expressions whose body is being typed.
+func localRefs(tokens []token) []string {
+	var locals []string
+	if len(tokens) > 1 && tokens[0].t == symbol("def") {
+		for i, t := range tokens {
+			if t.t == byte('=') {
+				for _, t := range tokens[1:i] {
+					if s, ok := t.t.(symbol); ok {
+						locals = append(locals, string(s))
+					}
+				}
+				tokens = tokens[i+1:]
+				break
+			}
+		}
+	}
+	// Each open bracket starts a frame of refs, which go out of scope when it closes.
+	type frame struct {
+		refs    []string
+		pending []string // let refs before their '|'
+	}
+	frames := []frame{{}}
+	for i := 0; i < len(tokens); i++ {
+		top := &frames[len(frames)-1]
+		switch t := tokens[i].t; {
+		case t == byte('(') || t == byte('['):
+			f := frame{}
+			if t == byte('[') {
+				if params, rest := parseLambdaBlockParams(tokens[i+1:]); len(rest) < len(tokens[i+1:]) {
+					f.refs = params
+					i = len(tokens) - len(rest) - 1
+				}
+			}
+			frames = append(frames, f)
+		case t == byte(')') || t == byte(']'):
+			if len(frames) > 1 {
+				frames = frames[:len(frames)-1]
+			}
+		case t == symbol("let") && i+2 < len(tokens) && tokens[i+2].t == byte('='):
+			if ref, ok := tokens[i+1].t.(symbol); ok && isRef(ref) {
+				top.pending = append(top.pending, string(ref))
+				i += 2
+			}
+		case t == byte('|') && len(top.pending) > 0:
+			top.refs = append(top.refs, top.pending[len(top.pending)-1])
+			top.pending = top.pending[:len(top.pending)-1]
+		}
+	}
+	for _, f := range frames {
+		locals = append(locals, f.refs...)
+	}
+	return locals
+}
+
 func parseSymbolQuery(tokens []token) (expr.Query, []token) {
 	stmt, rest := parseStmt(tokens)
-	var e expr.Expr
-	switch stmt := stmt.(type) {
-	case nil:
+	if stmt == nil {
 		return nil, tokens
-	case *expr.ExprStmt:
-		e = stmt.Expr
-	case *expr.AssignStmt:
-		e = stmt.Expr
 	}
-	switch e := e.(type) {
+	operand, lets := rightOperand(queryExpr(stmt))
+	switch e := operand.(type) {
 	case *expr.MessageExpr:
 		switch s := e.Message.(type) {
 		case *expr.SymbolExpr:
 			return &expr.SymbolQuery{
-				Expr:         e.Receiver,
+				Expr:         withLets(lets, e.Receiver),
 				Symbol:       s.Symbol,
 				SymbolOffset: s.Offset,
 			}, rest
@@ -213,19 +572,53 @@ func parseSymbolQuery(tokens []token) (expr.Query, []token) {
 
 func parseEmptySymbolQuery(code string, tokens []token) (expr.Query, error) {
 	stmt, rest := parseStmt(tokens)
+	// A trailing |> starts a stage that the pipeline so far is applied to, typically a function bound
+	// to a ref.
+	if stmt != nil && len(rest) == 1 && rest[0].t == pipe {
+		return &expr.RefQuery{
+			Ref:       "",
+			RefOffset: utf8.RuneCountInString(code),
+			Locals:    localRefs(tokens[:len(tokens)-1]),
+		}, nil
+	}
 	if stmt == nil || len(rest) > 0 {
 		return nil, fmt.Errorf("could not parse statement in query")
 	}
-	var e expr.Expr
-	switch stmt := stmt.(type) {
-	case *expr.ExprStmt:
-		e = stmt.Expr
-	case *expr.AssignStmt:
-		e = stmt.Expr
-	}
+	operand, lets := rightOperand(queryExpr(stmt))
 	return &expr.SymbolQuery{
-		Expr:         e,
+		Expr:         withLets(lets, operand),
 		Symbol:       "",
-		SymbolOffset: len(code),
+		SymbolOffset: utf8.RuneCountInString(code),
 	}, nil
 }
+
+// rightOperand finds the rightmost operand of infix operator applications, pipelines and let bodies,
+// which is where the next message typed would be sent. It also returns the enclosing let expressions,
+// outermost first, whose bindings are in scope of the operand.
+func rightOperand(e expr.Expr) (expr.Expr, []*expr.LetExpr) {
+	var lets []*expr.LetExpr
+	for {
+		switch x := e.(type) {
+		case *expr.MessageExpr:
+			if !x.Infix {
+				return e, lets
+			}
+			e = x.Message
+		case *expr.PipeExpr:
+			e = x.Right
+		case *expr.LetExpr:
+			lets = append(lets, x)
+			e = x.Body
+		default:
+			return e, lets
+		}
+	}
+}
+
+// withLets evaluates e in the scope of the bindings of lets, for completing inside let bodies.
+func withLets(lets []*expr.LetExpr, e expr.Expr) expr.Expr {
+	for i := len(lets) - 1; i >= 0; i-- {
+		e = &expr.LetExpr{Ref: lets[i].Ref, Value: lets[i].Value, Body: e}
+	}
+	return e
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	cl "github.com/t0yv0/complang"
 	"github.com/t0yv0/complang/expr"
 )
 
@@ -46,11 +47,30 @@ func TestParseStmt(t *testing.T) {
 	str, ok := stmt.Expr.(*expr.StringExpr)
 	assert.True(t, ok)
 	assert.Equal(t, "$foo", str.String)
+
+	for code, message := range map[string]string{
+		"$x f)":       "unexpected ')'",
+		"$x ()":       "unexpected '('",
+		"$x = 1 ]":    "unexpected ']'",
+		"$x [$y | ":   "unexpected '['",
+		"(1 + 2":      "unexpected end of input",
+		"$x = ($m (a": "unexpected end of input",
+	} {
+		_, err := ParseStmt(code)
+		assert.EqualError(t, err, message, code)
+	}
+	_, err = ParseExpr("(1 + 2")
+	assert.EqualError(t, err, "unexpected end of input")
+
+	// Queries complete inside parentheses that are still open.
+	q, err := ParseQuery("(1 + $m a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", q.(*expr.SymbolQuery).Symbol)
 }
 
 func TestParseQuery(t *testing.T) {
 	t.Run("SymbolQuery", func(t *testing.T) {
-		for _, code := range []string{"$obj f", "$v = $obj f"} {
+		for _, code := range []string{"$obj f", "$v = $obj f", "$a + $obj f", "$a |> $obj f"} {
 			q, err := ParseQuery(code)
 			assert.NoError(t, err)
 			sq, ok := q.(*expr.SymbolQuery)
@@ -62,7 +82,7 @@ func TestParseQuery(t *testing.T) {
 		}
 	})
 	t.Run("SymbolQuery/empty", func(t *testing.T) {
-		for _, code := range []string{"$obj ", "$v = $obj "} {
+		for _, code := range []string{"$obj ", "$v = $obj ", "$a * 2 < $obj "} {
 			q, err := ParseQuery(code)
 			assert.NoError(t, err)
 			sq, ok := q.(*expr.SymbolQuery)
@@ -90,3 +110,220 @@ func TestParseQuery(t *testing.T) {
 		}
 	})
 }
+
+func TestParseInfix(t *testing.T) {
+	// sexp shows sends as (receiver message), marking infix sends with a '!'.
+	var sexp func(e expr.Expr) string
+	sexp = func(e expr.Expr) string {
+		switch e := e.(type) {
+		case *expr.MessageExpr:
+			if e.Infix {
+				return "(" + sexp(e.Receiver) + " " + sexp(e.Message) + ")!"
+			}
+			return "(" + sexp(e.Receiver) + " " + sexp(e.Message) + ")"
+		case *expr.RefExpr:
+			return e.Ref
+		case *expr.SymbolExpr:
+			return e.Symbol
+		case *expr.NumExpr:
+			return e.Number.RatString()
+		default:
+			return "?"
+		}
+	}
+	for code, expected := range map[string]string{
+		"$a + 1":            "(($a +) 1)!",
+		"$a - 1 - 2":        "(((($a -) 1)! -) 2)!",
+		"$a -1":             "($a -1)",
+		"$a + $b * 2":       "(($a +) (($b *) 2)!)!",
+		"$a f + $b g == 3":  "((((($a f) +) ($b g))! ==) 3)!",
+		"$a / ($b + 1) > 0": "(((($a /) (($b +) 1)!)! >) 0)!",
+	} {
+		t.Run(code, func(t *testing.T) {
+			e, err := ParseExpr(code)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, sexp(e))
+		})
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	s, err := ParseStmt("$v = $x |> $g |> $f a")
+	assert.NoError(t, err)
+	p, ok := s.(*expr.AssignStmt).Expr.(*expr.PipeExpr)
+	assert.True(t, ok)
+	assert.Equal(t, "$f", p.Right.(*expr.MessageExpr).Receiver.(*expr.RefExpr).Ref)
+	left, ok := p.Left.(*expr.PipeExpr)
+	assert.True(t, ok)
+	assert.Equal(t, "$g $x", expr.String(left.Expand()))
+
+	t.Run("SymbolQuery", func(t *testing.T) {
+		for code, ref := range map[string]string{
+			"$x |> $f a": "$f",
+			"$x |> $f ":  "$f",
+		} {
+			q, err := ParseQuery(code)
+			assert.NoError(t, err)
+			sq := q.(*expr.SymbolQuery)
+			switch e := sq.Expr.(type) {
+			case *expr.RefExpr:
+				assert.Equal(t, ref, e.Ref, code)
+			case *expr.MessageExpr:
+				assert.Equal(t, ref, e.Receiver.(*expr.RefExpr).Ref, code)
+			}
+		}
+	})
+
+	t.Run("RefQuery", func(t *testing.T) {
+		// A stage is applied to the pipeline so far, so it starts with a function rather than a message.
+		q, err := ParseQuery("def $f $y = $y |> ")
+		assert.NoError(t, err)
+		assert.Equal(t, &expr.RefQuery{Ref: "", RefOffset: 18, Locals: []string{"$f", "$y"}}, q)
+
+		q, err = ParseQuery("$x |> [$d | $d |> ")
+		assert.NoError(t, err)
+		assert.Equal(t, &expr.RefQuery{Ref: "", RefOffset: 18, Locals: []string{"$d"}}, q)
+	})
+
+	t.Run("SymbolQuery/stage", func(t *testing.T) {
+		// The last parameter of a lambda block stage is bound to the value piped into it.
+		for code, expected := range map[string]string{
+			"$x |> [$d | $d na":                "let $d = $x | $d",
+			"$v = $x |> [$a $d | $d ":          "let $d = $x | $d",
+			"def $f $y = $y |> [$d | ($d na":   "let $d = $y | $d",
+			"$f ($x |> [$d | $d na":            "let $d = $x | $d",
+			"$x |> [$d | $d |> [$e | $e na":    "let $d = $x | let $e = $d | $e",
+			"$x |> [$d | $d one] |> [$e | $e ": "let $e = $x |> [$d | $d one] | $e",
+			"$x |> [$d | $d] na":               "[$d | $d]",
+		} {
+			q, err := ParseQuery(code)
+			assert.NoError(t, err, code)
+			sq, ok := q.(*expr.SymbolQuery)
+			assert.True(t, ok, code)
+			assert.Equal(t, expected, expr.String(sq.Expr), code)
+		}
+	})
+}
+
+func TestParseInterpolatedString(t *testing.T) {
+	e, err := ParseExpr(`"a ${$x f} \${b}"`)
+	assert.NoError(t, err)
+	s, ok := e.(*expr.InterpolatedStringExpr)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(s.Parts))
+	assert.Equal(t, "a ", s.Parts[0].(*expr.StringExpr).String)
+	ref := s.Parts[1].(*expr.MessageExpr).Receiver.(*expr.RefExpr)
+	assert.Equal(t, 5, ref.Offset)
+	assert.Equal(t, " ${b}", s.Parts[2].(*expr.StringExpr).String)
+
+	_, err = ParseExpr(`"a ${$x f"`)
+	assert.Error(t, err)
+}
+
+func TestParseQueryUnicode(t *testing.T) {
+	for code, offset := range map[string]int{"$café na": 6, "$café ": 6, `"é" + $m `: 9} {
+		q, err := ParseQuery(code)
+		assert.NoError(t, err)
+		assert.Equal(t, offset, q.Offset(), code)
+	}
+}
+
+func TestParseQuotedSymbolQuery(t *testing.T) {
+	for code, symbol := range map[string]string{
+		"$m `key wi":     "key wi",
+		"$m `key with ":  "key with ",
+		"$m `a b` `c d`": "c d",
+	} {
+		q, err := ParseQuery(code)
+		assert.NoError(t, err)
+		sq, ok := q.(*expr.SymbolQuery)
+		assert.True(t, ok, code)
+		assert.Equal(t, symbol, sq.Symbol, code)
+	}
+	e, err := ParseExpr("$m `key with spaces`")
+	assert.NoError(t, err)
+	assert.Equal(t, "key with spaces", e.(*expr.MessageExpr).Message.(*expr.SymbolExpr).Symbol)
+}
+
+func TestParseLet(t *testing.T) {
+	for code, expected := range map[string]string{
+		"let $x = $y size | $x * $x":             "let $x = $y size | $x * $x",
+		"$f (let $x = 1 | $x) g":                 "$f (let $x = 1 | $x) g",
+		"let $x = 1 | let $y = $x + 1 | $y":      "let $x = 1 | let $y = $x + 1 | $y",
+		"let $x = 1 | $x |> f":                   "let $x = 1 | $x |> f",
+		"[$z | let $x = $z | $x]":                "[$z | let $x = $z | $x]",
+		"$m let":                                 "$m let",
+		"$m let $x":                              "$m let $x",
+		"$v = (let $x = 1 | $x) + (let $y=2|$y)": "$v = (let $x = 1 | $x) + (let $y = 2 | $y)",
+	} {
+		s, err := ParseStmt(code)
+		assert.NoError(t, err, code)
+		assert.Equal(t, expected, expr.String(s), code)
+	}
+	e, err := ParseExpr("let $x = 1 | $x f")
+	assert.NoError(t, err)
+	assert.Equal(t, cl.Span{Offset: 0, Length: 17}, expr.Span(e))
+
+	t.Run("SymbolQuery", func(t *testing.T) {
+		for code, expected := range map[string]string{
+			"let $x = $m | $x na":               "let $x = $m | $x",
+			"let $x = $m | $x ":                 "let $x = $m | $x",
+			"let $x = $m | 1 + $x a ":           "let $x = $m | $x a",
+			"let $x = $m | let $y = $x | $y na": "let $x = $m | let $y = $x | $y",
+			"let $x = $m na":                    "$m",
+			"$v = let $x = $m ":                 "$m",
+		} {
+			q, err := ParseQuery(code)
+			assert.NoError(t, err, code)
+			sq, ok := q.(*expr.SymbolQuery)
+			assert.True(t, ok, code)
+			assert.Equal(t, expected, expr.String(sq.Expr), code)
+		}
+	})
+}
+
+func TestParseDef(t *testing.T) {
+	for code, expected := range map[string]string{
+		`def $f = 1`:                        `def $f = 1`,
+		`def $f $x $y "Adds." = $x + $y`:    `def $f $x $y "Adds." = $x + $y`,
+		`def $f $x "\${not} embedded" = $x`: `def $f $x "\${not} embedded" = $x`,
+		`def $f $x = $x |> g`:               `def $f $x = $x |> g`,
+		`def $x`:                            `def $x`,
+		`$m def`:                            `$m def`,
+	} {
+		s, err := ParseStmt(code)
+		assert.NoError(t, err, code)
+		assert.Equal(t, expected, expr.String(s), code)
+	}
+	for _, code := range []string{`def = 1`, `def $f $x "${$x}" = $x`, `def $f $x = `} {
+		_, err := ParseStmt(code)
+		assert.Error(t, err, code)
+	}
+
+	t.Run("SymbolQuery", func(t *testing.T) {
+		q, err := ParseQuery("def $f $x = $x na")
+		assert.NoError(t, err)
+		assert.Equal(t, "$x na", expr.String(q))
+	})
+
+	t.Run("RefQuery", func(t *testing.T) {
+		for code, expected := range map[string][]string{
+			"$x":                                nil,
+			"def $f $x $y = $x + $":             {"$f", "$x", "$y"},
+			"def $f $x = [$y | $y] $":           {"$f", "$x"},
+			"[$y $z | $":                        {"$y", "$z"},
+			"[$y | let $a = 1 | [$b | $a] $":    {"$y", "$a"},
+			"let $a = $":                        nil,
+			"let $a = let $b = 1 | $b | $":      {"$b", "$a"},
+			"(let $a = 1 | $a) $":               nil,
+			"$m |> [$x | $x] |> [$y | $std f $": {"$y"},
+		} {
+			q, err := ParseQuery(code)
+			assert.NoError(t, err, code)
+			rq, ok := q.(*expr.RefQuery)
+			if assert.True(t, ok, code) {
+				assert.Equal(t, expected, rq.Locals, code)
+			}
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t0yv0/complang/expr"
+)
+
+// randomStmt generates statements that the parser can produce.
+type randomStmt struct {
+	stmt expr.Stmt
+}
+
+func (randomStmt) Generate(r *rand.Rand, size int) reflect.Value {
+	g := &generator{r: r}
+	e := g.pipeline(3)
+	switch r.Intn(6) {
+	case 0, 1:
+		return reflect.ValueOf(randomStmt{&expr.AssignStmt{Ref: g.ref(), Expr: e}})
+	case 2:
+		var params []string
+		for i := 0; i < r.Intn(3); i++ {
+			params = append(params, g.ref())
+		}
+		return reflect.ValueOf(randomStmt{&expr.DefStmt{Ref: g.ref(), Params: params, Doc: g.text(), Body: e}})
+	}
+	return reflect.ValueOf(randomStmt{&expr.ExprStmt{Expr: e}})
+}
+
+type generator struct {
+	r *rand.Rand
+}
+
+func (g *generator) pick(choices ...string) string {
+	return choices[g.r.Intn(len(choices))]
+}
+
+func (g *generator) ref() string {
+	return g.pick("$x", "$y", "$long_name", "$_1", "$ünï")
+}
+
+func (g *generator) text() string {
+	return g.pick("", "a", "a b", "${", "$", "\"q\"", "\\", "`", "\n\t", "\x01", "é😀", "}", "]")
+}
+
+func (g *generator) pipeline(depth int) expr.Expr {
+	e := g.expr(depth)
+	for g.r.Intn(3) == 0 {
+		e = &expr.PipeExpr{Left: e, Right: g.expr(depth)}
+	}
+	return e
+}
+
+func (g *generator) expr(depth int) expr.Expr {
+	if depth == 0 {
+		return g.simple(0)
+	}
+	switch g.r.Intn(4) {
+	case 0:
+		op := g.pick("==", "!=", "<", ">", "<=", ">=", "+", "-", "*", "/")
+		return &expr.MessageExpr{
+			Receiver: &expr.MessageExpr{Receiver: g.expr(depth - 1), Message: &expr.SymbolExpr{Symbol: op}},
+			Message:  g.expr(depth - 1),
+			Infix:    true,
+		}
+	case 1:
+		receiver := g.expr(depth - 1)
+		if m, ok := receiver.(*expr.MessageExpr); ok && !m.Infix && g.r.Intn(2) == 0 {
+			m.Grouped = true
+		}
+		return &expr.MessageExpr{Receiver: receiver, Message: g.expr(depth - 1)}
+	default:
+		return g.simple(depth)
+	}
+}
+
+func (g *generator) simple(depth int) expr.Expr {
+	n := 7
+	if depth > 0 {
+		n = 10
+	}
+	switch g.r.Intn(n) {
+	case 0:
+		return &expr.RefExpr{Ref: g.ref()}
+	case 1:
+		return &expr.SymbolExpr{Symbol: g.pick(g.text(), "name", "a.b/c-d", "null", "+", "1x", "$x", "let", "def")}
+	case 2:
+		return &expr.NullExpr{}
+	case 3:
+		return &expr.BoolExpr{Bool: g.r.Intn(2) == 0}
+	case 4:
+		// Only finite decimals print as number literals.
+		n := big.NewRat(g.r.Int63n(20001)-10000, []int64{1, 10, 100, 8}[g.r.Intn(4)])
+		return &expr.NumExpr{Number: n}
+	case 5, 6:
+		return &expr.StringExpr{String: g.text()}
+	case 7:
+		parts := []expr.Expr{}
+		for i := 0; i < 1+g.r.Intn(3); i++ {
+			if s := g.text(); s != "" {
+				parts = append(parts, &expr.StringExpr{String: s})
+			}
+			// Embedded string literals are indistinguishable from literal text.
+			e := g.expr(depth - 1)
+			for _, ok := e.(*expr.StringExpr); ok; _, ok = e.(*expr.StringExpr) {
+				e = g.expr(depth - 1)
+			}
+			parts = append(parts, e)
+		}
+		return &expr.InterpolatedStringExpr{Parts: parts}
+	case 8:
+		return &expr.LetExpr{Ref: g.ref(), Value: g.expr(depth - 1), Body: g.expr(depth - 1)}
+	default:
+		var params []string
+		for i := 0; i < g.r.Intn(3); i++ {
+			params = append(params, g.ref())
+		}
+		var body expr.Expr
+		if g.r.Intn(5) > 0 {
+			body = g.expr(depth - 1)
+		}
+		return &expr.LambdaBlockExpr{Symbols: params, Body: body}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	roundTrip := func(s randomStmt) bool {
+		code := expr.String(s.stmt)
+		parsed, err := ParseStmt(code)
+		if !assert.NoError(t, err, code) {
+			return false
+		}
+		return assert.Equal(t, expr.Dump(s.stmt), expr.Dump(parsed), code)
+	}
+	err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(1))})
+	assert.NoError(t, err)
+}
+
+func TestRoundTripFormat(t *testing.T) {
+	roundTrip := func(s randomStmt) bool {
+		code := FormatStmt(s.stmt)
+		parsed, err := ParseStmt(code)
+		if !assert.NoError(t, err, code) {
+			return false
+		}
+		return assert.Equal(t, code, FormatStmt(parsed))
+	}
+	err := quick.Check(roundTrip, &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(2))})
+	assert.NoError(t, err)
+}
+
+func TestRoundTripCases(t *testing.T) {
+	for _, code := range []string{
+		"[[$x | $x]]",
+		"[[$x | $x] f]",
+		"\"${`}` name}\"",
+		"$x = 1 + 2 * (3 - 4) |> f |> [$y | $y g]",
+		"def $f $x \"Doc with \\${\" = $f ($x - 1)",
+		"`a b` (null == true) \"${$x}\\${\"",
+		"let $x = let $y = 1 | $y | $x |> (let $z = 2 | $z) let",
+	} {
+		s, err := ParseStmt(code)
+		assert.NoError(t, err)
+		assert.Equal(t, code, expr.String(s))
+	}
+}
@@ -7,3 +7,22 @@ type token struct {
 }
 
 type symbol string
+
+// number is the source text of a numeric literal.
+type number string
+
+// operator is an infix operator such as + or <=.
+type operator string
+
+// pipe separates the stages of a pipeline.
+const pipe = operator("|>")
+
+// interpolation is a string literal with embedded expressions. Its parts are either literal strings
+// or the tokens of an embedded expression.
+type interpolation []any
+
+// quotedSymbol is a symbol written in backticks. Unlike symbol it is never a ref or a keyword.
+type quotedSymbol string
+
+// comment is a line comment starting with # and running to the end of the line.
+type comment string
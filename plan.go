@@ -0,0 +1,99 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// :plan -- objects respond to :plan by describing the side-effects they would perform when
+// responding to :run, without performing them.
+type PlanRequest struct {
+	Receiver func(step PlanStep)
+}
+
+func (pr PlanRequest) Message(ctx context.Context, v Value) Value {
+	switch v.(type) {
+	case ShowMessage:
+		return StringValue{":plan"}
+	case RunMessage:
+		return pr
+	default:
+		return DoNotUnderstandError(ctx, pr, v)
+	}
+}
+
+// PlanStep describes a single side-effect.
+type PlanStep struct {
+	Description string
+	Effect      Effect
+	// Target names what the step acts on, such as the first argument of a bound method. Users
+	// confirm destructive steps by typing the target.
+	Target string
+}
+
+// Plan lists the side-effects that running v would perform. Values that do not understand :plan are
+// reported as a single opaque step. Like Run, it steps through transparent closures in a loop.
+func Plan(ctx context.Context, v Value) []PlanStep {
+	for next, ok := step(ctx, v); ok; next, ok = step(ctx, v) {
+		v = next
+	}
+	switch v.(type) {
+	case NullValue, BoolValue, StringValue, NumValue, SliceValue, MapValue:
+		return nil // these respond to :run with themselves
+	}
+	if IsError(v) {
+		return nil
+	}
+	var steps []PlanStep
+	resp := v.Message(ctx, PlanRequest{Receiver: func(step PlanStep) {
+		steps = append(steps, step)
+	}})
+	if _, ok := resp.(*doesNotUnderstandError); ok {
+		return []PlanStep{{
+			Description: fmt.Sprintf("run %s", Show(ctx, v)),
+			Effect:      UndeclaredEffect,
+			Target:      Show(ctx, v),
+		}}
+	}
+	return steps
+}
+
+func (c Closure) plan(ctx context.Context, req PlanRequest) {
+	if len(c.Params) > 0 {
+		return // responds to :run with itself
+	}
+	if !c.Transparent {
+		target := c.Name
+		if len(c.Args) > 0 {
+			target = Show(ctx, c.Args[0])
+		}
+		req.Receiver(PlanStep{
+			Description: fmt.Sprintf("call %s", c.describe(ctx)),
+			Effect:      c.Effect,
+			Target:      target,
+		})
+		for _, msg := range c.PostRun {
+			req.Receiver(PlanStep{
+				Description: fmt.Sprintf("send %s to the result", Show(ctx, msg)),
+				Effect:      ReadEffect, // sending messages is free of side-effects
+			})
+		}
+		return
+	}
+	for _, s := range Plan(ctx, c) {
+		req.Receiver(s)
+	}
+}
+
+// describe renders a closure together with the arguments applied to it.
+func (c Closure) describe(ctx context.Context) string {
+	parts := []string{c.Name}
+	if c.Name == "" {
+		parts[0] = c.show()
+	}
+	for _, a := range c.Args {
+		parts = append(parts, Show(ctx, a))
+	}
+	return strings.Join(parts, " ")
+}
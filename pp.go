@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 )
@@ -32,13 +33,18 @@ func pretty(value any, maxHeight, maxWidth int) string {
 	value = unpack(value)
 	s := preparePretty(value)
 	parts := strings.Split(s, "\n")
+	truncated := false
 	for i := 0; i < len(parts); i++ {
-		if maxWidth >= 0 && len(parts[i]) > maxWidth {
-			parts[i] = parts[i][0:maxWidth] + "..."
+		if maxWidth >= 0 && utf8.RuneCountInString(parts[i]) > maxWidth {
+			parts[i] = string([]rune(parts[i])[0:maxWidth]) + "..."
+			truncated = true
 		}
 	}
 	if maxHeight >= 0 && len(parts) > maxHeight {
-		return strings.Join(parts[0:32], "\n") + "\n..."
+		return strings.Join(parts[0:maxHeight], "\n") + "\n..."
+	}
+	if truncated {
+		return strings.Join(parts, "\n")
 	}
 	return s
 }
@@ -68,6 +74,17 @@ func unpack(v any) any {
 			result = append(result, unpack(e))
 		}
 		return result
+	case NumValue:
+		// Show numbers exactly rather than as the fields of *big.Rat.
+		tag := "!!float"
+		if v.Num.IsInt() {
+			tag = "!!int"
+		}
+		text := FormatNum(v.Num)
+		if strings.Contains(text, "/") {
+			tag = "!!str"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: text}
 	default:
 		return v
 	}
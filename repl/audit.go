@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// auditRecord is written as a JSON line to the audit log for every side-effect performed or refused.
+// The receiver is the expression that reached the closure, as the statement wrote it.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Statement string    `json:"statement"`
+	Receiver  string    `json:"receiver"`
+	Effect    string    `json:"effect"`
+	Args      []string  `json:"args"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// audit returns a context that logs the side-effects performed or refused while running statement.
+func (re *repl) audit(ctx context.Context, statement string) context.Context {
+	if re.auditLog == nil {
+		return ctx
+	}
+	return cl.WithRunObserver(ctx, func(ctx context.Context, c cl.Closure, result cl.Value) {
+		r := auditRecord{
+			Receiver: c.Path,
+			Effect:   c.Effect.Normalize().String(),
+			Args:     []string{},
+		}
+		if r.Receiver == "" {
+			r.Receiver = c.Name
+		}
+		for _, a := range c.Args {
+			r.Args = append(r.Args, cl.Show(ctx, a))
+		}
+		if cl.IsError(result) {
+			r.Error = cl.Show(ctx, result)
+		} else {
+			r.Result = cl.Show(ctx, result)
+		}
+		re.writeAudit(statement, r)
+	})
+}
+
+// auditRefusal logs a statement that the effect policy refused to run.
+func (re *repl) auditRefusal(statement string, effect cl.Effect) {
+	if re.auditLog == nil {
+		return
+	}
+	re.writeAudit(statement, auditRecord{
+		Effect: effect.String(),
+		Args:   []string{},
+		Error:  fmt.Sprintf("refused: %s effects are not allowed", effect),
+	})
+}
+
+func (re *repl) writeAudit(statement string, r auditRecord) {
+	r.Time = time.Now().UTC()
+	r.User = currentUser()
+	r.Statement = statement
+	if err := json.NewEncoder(re.auditLog).Encode(r); err != nil {
+		fmt.Println(re.color.paint(colorRed, fmt.Sprintf("Error writing audit log: %v", err)))
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
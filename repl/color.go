@@ -0,0 +1,160 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	cl "github.com/t0yv0/complang"
+	"github.com/t0yv0/complang/parser"
+	"golang.org/x/term"
+)
+
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[1m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorGray    = "\x1b[90m"
+)
+
+// colorizer adds ANSI colors to echoed input and rendered values.
+type colorizer struct {
+	enabled bool
+}
+
+// newColorizer enables colors only when stdout is a terminal and NO_COLOR is not set.
+func newColorizer() *colorizer {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return &colorizer{
+		enabled: !noColor && term.IsTerminal(int(os.Stdout.Fd())),
+	}
+}
+
+func (c *colorizer) paint(color, text string) string {
+	if !c.enabled || color == "" || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// highlight colors source code by the lexical class of its tokens.
+func (c *colorizer) highlight(code string) string {
+	if !c.enabled {
+		return code
+	}
+	var sb strings.Builder
+	runes := []rune(code)
+	pos := 0
+	for _, t := range parser.Tokens(code) {
+		sb.WriteString(string(runes[pos:t.Offset]))
+		sb.WriteString(c.paint(tokenColor(t.Kind), string(runes[t.Offset:t.Offset+t.Length])))
+		pos = t.Offset + t.Length
+	}
+	sb.WriteString(string(runes[pos:]))
+	return sb.String()
+}
+
+// echo redraws the line the user just submitted with syntax highlighting; liner has no hook to
+// highlight while typing.
+func (c *colorizer) echo(prompt, line string) {
+	if !c.enabled {
+		return
+	}
+	rows := 1
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		rows = (len([]rune(prompt+line)) + width - 1) / width
+		if rows < 1 {
+			rows = 1
+		}
+	}
+	fmt.Printf("\x1b[%dA\r\x1b[J%s%s\n", rows, prompt, c.highlight(line))
+}
+
+// value colors the text of a rendered value: errors in red, and keys, strings, numbers, booleans
+// and nulls of structured values each in their own color.
+func (c *colorizer) value(ctx context.Context, v cl.Value, text string) string {
+	if !c.enabled {
+		return text
+	}
+	switch v.(type) {
+	case cl.NullValue:
+		return c.paint(colorGray, text)
+	case cl.BoolValue:
+		return c.paint(colorYellow, text)
+	case cl.NumValue:
+		return c.paint(colorCyan, text)
+	case cl.StringValue:
+		return c.paint(colorGreen, text)
+	case cl.MapValue, cl.SliceValue:
+		return c.yaml(text)
+	}
+	if cl.IsError(v) {
+		return c.paint(colorRed, text)
+	}
+	return text
+}
+
+var (
+	yamlLinePattern = regexp.MustCompile(`^(\s*(?:- )*)(.*)$`)
+	yamlKeyPattern  = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|'(?:[^']|'')*'|[^\s"'#][^:]*?):(\s.*|)$`)
+	yamlNumPattern  = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+func (c *colorizer) yaml(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		m := yamlLinePattern.FindStringSubmatch(line)
+		indent, rest := m[1], m[2]
+		if k := yamlKeyPattern.FindStringSubmatch(rest); k != nil {
+			lines[i] = indent + c.paint(colorBlue, k[1]) + ":" + c.yamlScalar(k[2])
+		} else {
+			lines[i] = indent + c.yamlScalar(rest)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *colorizer) yamlScalar(s string) string {
+	value := strings.TrimLeft(s, " ")
+	space := s[:len(s)-len(value)]
+	switch {
+	case value == "":
+		return s
+	case value == "null" || value == "~":
+		return space + c.paint(colorGray, value)
+	case value == "true" || value == "false":
+		return space + c.paint(colorYellow, value)
+	case yamlNumPattern.MatchString(value):
+		return space + c.paint(colorCyan, value)
+	case value == "{}" || value == "[]" || value == "|" || value == "|-" || value == "...":
+		return s
+	default:
+		return space + c.paint(colorGreen, value)
+	}
+}
+
+func tokenColor(k parser.TokenKind) string {
+	switch k {
+	case parser.RefToken:
+		return colorMagenta
+	case parser.StringToken:
+		return colorGreen
+	case parser.NumberToken:
+		return colorCyan
+	case parser.BoolToken:
+		return colorYellow
+	case parser.NullToken, parser.CommentToken:
+		return colorGray
+	case parser.BracketToken, parser.PunctuationToken, parser.OperatorToken:
+		return colorBold
+	default:
+		return ""
+	}
+}
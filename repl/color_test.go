@@ -0,0 +1,56 @@
+package repl
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cl "github.com/t0yv0/complang"
+)
+
+func TestPaint(t *testing.T) {
+	on, off := &colorizer{enabled: true}, &colorizer{enabled: false}
+	assert.Equal(t, colorRed+"err"+colorReset, on.paint(colorRed, "err"))
+	assert.Equal(t, "err", off.paint(colorRed, "err"))
+	assert.Equal(t, "plain", on.paint("", "plain"))
+	assert.Equal(t, "", on.paint(colorRed, ""), "empty text needs no escapes")
+}
+
+func TestValueColors(t *testing.T) {
+	ctx := context.Background()
+	c := &colorizer{enabled: true}
+	for _, tc := range []struct {
+		value    cl.Value
+		text     string
+		expected string
+	}{
+		{cl.NullValue{}, "null", colorGray + "null" + colorReset},
+		{cl.BoolValue{Bool: true}, "true", colorYellow + "true" + colorReset},
+		{cl.NumValue{Num: big.NewRat(1, 2)}, "0.5", colorCyan + "0.5" + colorReset},
+		{cl.StringValue{Text: "s"}, "s", colorGreen + "s" + colorReset},
+		{cl.Error{ErrorMessage: "e"}, "ERROR: e", colorRed + "ERROR: e" + colorReset},
+		{cl.Closure{}, "<Closure>", "<Closure>"},
+		{
+			cl.MapValue{},
+			"a: 1\nb:\n  - true\n  - text",
+			colorBlue + "a" + colorReset + ": " + colorCyan + "1" + colorReset + "\n" +
+				colorBlue + "b" + colorReset + ":\n" +
+				"  - " + colorYellow + "true" + colorReset + "\n" +
+				"  - " + colorGreen + "text" + colorReset,
+		},
+	} {
+		assert.Equal(t, tc.expected, c.value(ctx, tc.value, tc.text), tc.text)
+	}
+	off := &colorizer{enabled: false}
+	assert.Equal(t, "null", off.value(ctx, cl.NullValue{}, "null"))
+}
+
+func TestHighlight(t *testing.T) {
+	c := &colorizer{enabled: true}
+	assert.Equal(t,
+		colorMagenta+"$x"+colorReset+" "+colorBold+"="+colorReset+" "+colorCyan+"1"+colorReset+" "+
+			colorGray+"# ü"+colorReset,
+		c.highlight("$x = 1 # ü"))
+	assert.Equal(t, "$x = 1", (&colorizer{}).highlight("$x = 1"))
+}
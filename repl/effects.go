@@ -0,0 +1,163 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/peterh/liner"
+	cl "github.com/t0yv0/complang"
+)
+
+// EffectAction is what the REPL does before running a statement with a given effect.
+type EffectAction int
+
+const (
+	// AllowEffect runs the statement without asking.
+	AllowEffect EffectAction = iota
+	// ConfirmEffect asks the user to confirm with y/N.
+	ConfirmEffect
+	// ConfirmTargetEffect asks the user to type the name of the target of the effect.
+	ConfirmTargetEffect
+	// RefuseEffect does not run the statement.
+	RefuseEffect
+)
+
+// EffectPolicy decides how the REPL handles statements by the strongest effect they would perform,
+// as reported by cl.Plan.
+type EffectPolicy struct {
+	Read        EffectAction
+	Write       EffectAction
+	Destructive EffectAction
+}
+
+// DefaultEffectPolicy runs reads, asks to confirm writes, and asks to type the target name of
+// destructive effects.
+var DefaultEffectPolicy = EffectPolicy{
+	Read:        AllowEffect,
+	Write:       ConfirmEffect,
+	Destructive: ConfirmTargetEffect,
+}
+
+// ReadOnlyEffectPolicy refuses all effects except reads.
+var ReadOnlyEffectPolicy = EffectPolicy{
+	Read:        AllowEffect,
+	Write:       RefuseEffect,
+	Destructive: RefuseEffect,
+}
+
+func (p EffectPolicy) action(e cl.Effect) EffectAction {
+	switch e.Normalize() {
+	case cl.ReadEffect:
+		return p.Read
+	case cl.WriteEffect:
+		return p.Write
+	default:
+		return p.Destructive
+	}
+}
+
+// limit enforces refused effects while the statement runs, covering effects that the plan could not
+// foresee.
+func (p EffectPolicy) limit(ctx context.Context) context.Context {
+	return p.limitTo(ctx, func(a EffectAction) bool { return a != RefuseEffect })
+}
+
+// unattended limits ctx to the effects that run without asking, for evaluation that the user did not
+// start, such as restoring a session.
+func (p EffectPolicy) unattended(ctx context.Context) context.Context {
+	return p.limitTo(ctx, func(a EffectAction) bool { return a == AllowEffect })
+}
+
+// limitTo limits ctx to the strongest effect whose action is allowed.
+func (p EffectPolicy) limitTo(ctx context.Context, allowed func(EffectAction) bool) context.Context {
+	for _, e := range []cl.Effect{cl.DestructiveEffect, cl.WriteEffect, cl.ReadEffect} {
+		if allowed(p.action(e)) {
+			return cl.WithEffectLimit(ctx, e)
+		}
+	}
+	return cl.WithEffectLimit(ctx, cl.UndeclaredEffect)
+}
+
+// authorize checks the effects of running v, the value of command, against the policy, asking the
+// user when needed. With preview set the plan is always displayed and confirmation is required even
+// for reads.
+func (re *repl) authorize(ctx context.Context, command string, v cl.Value, preview bool) (bool, error) {
+	if re.policy == nil {
+		return true, nil
+	}
+	steps := cl.Plan(ctx, v)
+	if preview && len(steps) == 0 {
+		fmt.Println("No side-effects")
+	}
+	if len(steps) == 0 && !preview {
+		return true, nil
+	}
+	effect := cl.StrongestEffect(steps)
+	action := re.policy.action(effect)
+	if preview && action == AllowEffect {
+		action = ConfirmEffect
+	}
+	if preview || action != AllowEffect {
+		for i, step := range steps {
+			fmt.Printf("%d. %s (%s)\n", i+1, step.Description, step.Effect.Normalize())
+		}
+	}
+	switch action {
+	case AllowEffect:
+		return true, nil
+	case RefuseEffect:
+		fmt.Println(re.color.paint(colorRed, refusal(effect)))
+		re.auditRefusal(command, effect)
+		return false, re.transcribe(transcriptEntry{command, refusal(effect), true})
+	case ConfirmTargetEffect:
+		target := ""
+		for _, step := range steps {
+			if step.Effect.Normalize() == effect {
+				target = step.Target
+				break
+			}
+		}
+		if target == "" {
+			// An empty target would be confirmed by just pressing Enter.
+			return re.confirm(fmt.Sprintf("Run %s effects without a named target? [y/N] ", effect))
+		}
+		answer, ok, err := re.ask(fmt.Sprintf("Type %q to confirm %s effects: ", target, effect))
+		if ok && answer == target {
+			return true, err
+		}
+		fmt.Println("Cancelled")
+		return false, err
+	default:
+		return re.confirm("Run? [y/N] ")
+	}
+}
+
+// refusal explains why a statement with the given effect does not run.
+func refusal(effect cl.Effect) string {
+	return fmt.Sprintf("Refused: %s effects are not allowed", effect)
+}
+
+// confirm asks a yes or no question, defaulting to no.
+func (re *repl) confirm(prompt string) (bool, error) {
+	answer, ok, err := re.ask(prompt)
+	if ok && (strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")) {
+		return true, err
+	}
+	fmt.Println("Cancelled")
+	return false, err
+}
+
+// ask prompts for an answer. It returns false if the user aborted the prompt.
+func (re *repl) ask(prompt string) (string, bool, error) {
+	answer, err := re.readLine(prompt)
+	switch {
+	case err == liner.ErrPromptAborted || err == io.EOF:
+		fmt.Println("")
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("Error reading line: %w", err)
+	}
+	return strings.TrimSpace(answer), true, nil
+}
@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"context"
+	"testing"
+	"unicode/utf8"
+
+	cl "github.com/t0yv0/complang"
+)
+
+type fuzzTarget struct {
+	Name string
+}
+
+func (fuzzTarget) Greet(name string) string {
+	return "hello " + name
+}
+
+func FuzzWordCompleter(f *testing.F) {
+	for _, s := range []string{"", "$", "$m ", "$m a", "$m `key w", "$t G", "$std tr", "$m a |> ",
+		"$m (", "[$x | $x ", "\"${$m ", "é $m", "$m a b c "} {
+		f.Add(s, utf8.RuneCountInString(s))
+	}
+	ctx := context.Background()
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{
+			"$m": cl.MapValue(map[string]cl.Value{
+				"a":        cl.SliceValue{cl.NewNum(1), cl.StringValue{Text: "x"}},
+				"key with": cl.BoolValue{Bool: true},
+			}),
+			"$t": cl.BindValue(fuzzTarget{Name: "t"}),
+		},
+	})
+	complete := re.newWordCompleter(ctx)
+	f.Fuzz(func(t *testing.T, line string, pos int) {
+		// liner passes a position within the line.
+		n := utf8.RuneCountInString(line)
+		if pos < 0 || pos > n {
+			pos = n
+		}
+		head, _, tail := complete(line, pos)
+		if !utf8.ValidString(line) {
+			return
+		}
+		if len(head)+len(tail) > len(line) {
+			t.Fatalf("completing %q at %d gave %q and %q", line, pos, head, tail)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package repl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	cl "github.com/t0yv0/complang"
+	"github.com/t0yv0/complang/parser"
+)
+
+// Main implements the command line of a complang binary embedding the REPL. Without arguments it
+// starts the REPL; `replay FILE...` replays recorded transcripts against the environment in cfg and
+// fails when their outputs differ; `fmt [-w] [-escape] FILE...` formats scripts, reading stdin without
+// files.
+func Main(ctx context.Context, cfg ReadEvalPrintLoopOptions, args []string) error {
+	flags := flag.NewFlagSet("complang", flag.ContinueOnError)
+	flags.StringVar(&cfg.TranscriptFile, "transcript", cfg.TranscriptFile,
+		"record statements and their output to this file")
+	readOnly := flags.Bool("read-only", false, "refuse to run statements with effects other than reads")
+	auditLog := flags.String("audit-log", "", "append a JSON line describing every side-effect to this file")
+	maxDepth := flags.Int("max-depth", cl.DefaultMaxDepth, "fail calls nested deeper than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ctx = cl.WithMaxDepth(ctx, *maxDepth)
+	if *readOnly {
+		cfg.EffectPolicy = &ReadOnlyEffectPolicy
+	}
+	if *auditLog != "" {
+		f, err := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("Error opening audit log: %w", err)
+		}
+		defer f.Close()
+		cfg.AuditLog = f
+	}
+	switch {
+	case flags.NArg() == 0:
+		return ReadEvalPrintLoop(ctx, cfg)
+	case flags.Arg(0) == "replay":
+		return replay(ctx, cfg, flags.Args()[1:])
+	case flags.Arg(0) == "fmt":
+		return format(flags.Args()[1:])
+	default:
+		return fmt.Errorf("unknown command: %s", flags.Arg(0))
+	}
+}
+
+func replay(ctx context.Context, cfg ReadEvalPrintLoopOptions, files []string) error {
+	failed := 0
+	for _, file := range files {
+		transcript, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		diff, err := ReplayTranscript(ctx, cfg, string(transcript))
+		if err != nil {
+			return err
+		}
+		if diff != "" {
+			fmt.Printf("%s:\n%s\n", file, diff)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transcripts do not match", failed, len(files))
+	}
+	return nil
+}
+
+// format prints scripts in canonical form, or with -w rewrites the files that are not formatted.
+// With -escape it first migrates scripts written before string interpolation, see
+// parser.EscapeInterpolation.
+func format(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	escape := flags.Bool("escape", false, "escape ${ in strings written before interpolation as \\${")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		code, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		formatted, err := formatCode(string(code), *escape)
+		if err != nil {
+			return fmt.Errorf("<stdin>: %w", err)
+		}
+		fmt.Print(formatted)
+		return nil
+	}
+	for _, file := range flags.Args() {
+		code, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		formatted, err := formatCode(string(code), *escape)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		switch {
+		case !*write:
+			fmt.Print(formatted)
+		case formatted != string(code):
+			if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatCode(code string, escape bool) (string, error) {
+	if escape {
+		code = parser.EscapeInterpolation(code)
+	}
+	return parser.FormatSource(code)
+}
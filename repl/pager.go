@@ -0,0 +1,280 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// pager displays output that is taller than the terminal.
+type pager struct {
+	// External pager command, taken from $PAGER. The built-in pager is used when empty.
+	command string
+	// Terminal state before liner switched it to raw mode, restored while an external pager runs.
+	origState *term.State
+	// Queries of the terminal, replaced in tests.
+	isTerminal func(fd int) bool
+	getSize    func(fd int) (width, height int, err error)
+}
+
+func newPager() *pager {
+	p := &pager{
+		command:    os.Getenv("PAGER"),
+		isTerminal: term.IsTerminal,
+		getSize:    term.GetSize,
+	}
+	if st, err := term.GetState(int(os.Stdin.Fd())); err == nil {
+		p.origState = st
+	}
+	return p
+}
+
+// enabled is true when both input and output are attached to a terminal.
+func (p *pager) enabled() bool {
+	return p.isTerminal(int(os.Stdin.Fd())) && p.isTerminal(int(os.Stdout.Fd()))
+}
+
+// fits checks if text can be printed without scrolling the prompt off the screen.
+func (p *pager) fits(text string) bool {
+	_, height, err := p.getSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return true
+	}
+	return len(splitLines(text)) < height
+}
+
+func (p *pager) page(text string) error {
+	if !p.enabled() {
+		fmt.Println(text)
+		return nil
+	}
+	if p.command != "" {
+		return p.external(text)
+	}
+	return p.builtin(text)
+}
+
+func (p *pager) external(text string) error {
+	fd := int(os.Stdin.Fd())
+	if p.origState != nil {
+		st, err := term.GetState(fd)
+		if err != nil {
+			return err
+		}
+		if err := term.Restore(fd, p.origState); err != nil {
+			return err
+		}
+		defer term.Restore(fd, st)
+	}
+	cmd := exec.Command("sh", "-c", p.command)
+	cmd.Stdin = strings.NewReader(text + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error running pager %q: %w", p.command, err)
+	}
+	return nil
+}
+
+func (p *pager) builtin(text string) error {
+	fd := int(os.Stdin.Fd())
+	st, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, st)
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprint(out, "\x1b[?1049h") // switch to the alternate screen
+	defer func() {
+		fmt.Fprint(out, "\x1b[?1049l")
+		out.Flush()
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	v := &pagerView{lines: splitLines(text)}
+	for {
+		width, height, err := p.getSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return err
+		}
+		v.height = height - 1 // reserve the status line
+		v.render(out, width)
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		k, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case "q", "Q", "\x03":
+			return nil
+		case "j", "\r", "\n", "down":
+			v.scroll(1)
+		case "k", "up":
+			v.scroll(-1)
+		case " ", "f", "pgdown":
+			v.scroll(v.height)
+		case "b", "pgup":
+			v.scroll(-v.height)
+		case "g", "home":
+			v.top = 0
+		case "G", "end":
+			v.scroll(len(v.lines))
+		case "/":
+			q, err := readSearch(out, in, height)
+			if err != nil {
+				return err
+			}
+			if q != "" {
+				v.query = q
+				v.search(v.top+1, 1)
+			}
+		case "n":
+			v.search(v.top+1, 1)
+		case "N":
+			v.search(v.top-1, -1)
+		}
+	}
+}
+
+// pagerView tracks the scroll position and the active search of the built-in pager.
+type pagerView struct {
+	lines   []string
+	top     int
+	height  int
+	query   string
+	message string
+}
+
+func (v *pagerView) scroll(n int) {
+	v.top += n
+	if max := len(v.lines) - v.height; v.top > max {
+		v.top = max
+	}
+	if v.top < 0 {
+		v.top = 0
+	}
+}
+
+// search moves to the closest line at or after start in the given direction that contains the query.
+func (v *pagerView) search(start, direction int) {
+	if v.query == "" {
+		return
+	}
+	for i := start; i >= 0 && i < len(v.lines); i += direction {
+		if strings.Contains(v.lines[i], v.query) {
+			v.top = i
+			v.scroll(0)
+			v.message = ""
+			return
+		}
+	}
+	v.message = fmt.Sprintf("Pattern not found: %s", v.query)
+}
+
+func (v *pagerView) render(w io.Writer, width int) {
+	fmt.Fprint(w, "\x1b[H\x1b[2J")
+	for i := v.top; i < v.top+v.height && i < len(v.lines); i++ {
+		line := v.lines[i]
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		if v.query != "" {
+			line = strings.ReplaceAll(line, v.query, "\x1b[7m"+v.query+"\x1b[27m")
+		}
+		fmt.Fprintf(w, "%s\r\n", line)
+	}
+	bottom := v.top + v.height
+	if bottom > len(v.lines) {
+		bottom = len(v.lines)
+	}
+	status := v.message
+	if status == "" {
+		status = fmt.Sprintf("lines %d-%d/%d (q quit, / search, n/N next/previous)",
+			v.top+1, bottom, len(v.lines))
+	}
+	fmt.Fprintf(w, "\x1b[%d;1H\x1b[7m%s\x1b[0m", v.height+1, status)
+	v.message = ""
+}
+
+// readKey reads a single key press, decoding the escape sequences of arrow and paging keys. An escape
+// that does not start such a sequence is returned as "esc", leaving the byte after it for the next key.
+func readKey(in *bufio.Reader) (string, error) {
+	b, err := in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b != '\x1b' {
+		return string(b), nil
+	}
+	if b, err = in.ReadByte(); err != nil {
+		return "", err
+	} else if b != '[' {
+		return "esc", in.UnreadByte()
+	}
+	seq := []byte{}
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return "up", nil
+	case "B":
+		return "down", nil
+	case "5~":
+		return "pgup", nil
+	case "6~":
+		return "pgdown", nil
+	case "H", "1~":
+		return "home", nil
+	case "F", "4~":
+		return "end", nil
+	default:
+		return "", nil
+	}
+}
+
+// readSearch reads a search query on the status line until Enter is pressed.
+func readSearch(w *bufio.Writer, in *bufio.Reader, height int) (string, error) {
+	var q []rune
+	for {
+		fmt.Fprintf(w, "\x1b[%d;1H\x1b[2K/%s", height, string(q))
+		if err := w.Flush(); err != nil {
+			return "", err
+		}
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			return string(q), nil
+		case '\x1b', '\x03':
+			return "", nil
+		case '\x7f', '\b':
+			if len(q) > 0 {
+				q = q[:len(q)-1]
+			}
+		default:
+			q = append(q, r)
+		}
+	}
+}
+
+func splitLines(text string) []string {
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,85 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTerminal makes a pager that sees the given file descriptors as terminals of the given height.
+func fakeTerminal(height int, terminals ...int) *pager {
+	return &pager{
+		isTerminal: func(fd int) bool {
+			for _, t := range terminals {
+				if fd == t {
+					return true
+				}
+			}
+			return false
+		},
+		getSize: func(fd int) (int, int, error) {
+			if height == 0 {
+				return 0, 0, errors.New("not a terminal")
+			}
+			return 80, height, nil
+		},
+	}
+}
+
+func TestPagerEnabled(t *testing.T) {
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	assert.True(t, fakeTerminal(24, stdin, stdout).enabled())
+	assert.False(t, fakeTerminal(24, stdout).enabled(), "input is redirected")
+	assert.False(t, fakeTerminal(24, stdin).enabled(), "output is redirected")
+}
+
+func TestPagerFits(t *testing.T) {
+	p := fakeTerminal(3)
+	assert.True(t, p.fits("one\ntwo"))
+	assert.True(t, p.fits("one\ntwo\n"), "a trailing newline does not count as a line")
+	assert.False(t, p.fits("one\ntwo\nthree"), "the prompt needs the last line")
+	assert.True(t, fakeTerminal(0).fits(strings.Repeat("line\n", 100)), "the size is unknown")
+}
+
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("\x1b[Aj\x1bq\x1b[5~"))
+	var keys []string
+	for {
+		k, err := readKey(in)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		keys = append(keys, k)
+	}
+	assert.Equal(t, []string{"up", "j", "esc", "q", "pgup"}, keys)
+}
+
+func TestPagerView(t *testing.T) {
+	v := &pagerView{lines: splitLines("a\nb\nc\nd\ne"), height: 2}
+	v.scroll(10)
+	assert.Equal(t, 3, v.top)
+	v.scroll(-10)
+	assert.Equal(t, 0, v.top)
+
+	v.query = "d"
+	v.search(v.top+1, 1)
+	assert.Equal(t, 3, v.top)
+	v.query = "x"
+	v.search(v.top+1, 1)
+	assert.Equal(t, 3, v.top)
+	assert.Equal(t, "Pattern not found: x", v.message)
+
+	var sb strings.Builder
+	v.query = "e"
+	v.render(&sb, 80)
+	assert.Equal(t, "\x1b[H\x1b[2Jd\r\n\x1b[7me\x1b[27m\r\n\x1b[3;1H\x1b[7mPattern not found: x\x1b[0m",
+		sb.String())
+	assert.Empty(t, v.message, "messages are shown once")
+}
@@ -6,23 +6,35 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"unicode/utf8"
 
 	fuzzyfinder "github.com/ktr0731/go-fuzzyfinder"
 	"github.com/peterh/liner"
 	cl "github.com/t0yv0/complang"
 	"github.com/t0yv0/complang/expr"
 	"github.com/t0yv0/complang/parser"
+	"github.com/t0yv0/complang/std"
 )
 
 type ReadEvalPrintLoopOptions struct {
 	HistoryFile        string
 	InitialEnvironment map[string]cl.Value
 	MaxCompletions     int
+	// SessionFile, when set, persists plain data bound with `$x = ...` and functions defined with def
+	// across REPL runs.
+	SessionFile string
+	// TranscriptFile, when set, records every statement and its rendered output for ReplayTranscript.
+	TranscriptFile string
+	// EffectPolicy decides which effects run without confirmation. Defaults to DefaultEffectPolicy.
+	EffectPolicy *EffectPolicy
+	// AuditLog, when set, receives a JSON line describing every side-effect performed.
+	AuditLog io.Writer
 }
 
 func ReadEvalPrintLoop(ctx context.Context, cfg ReadEvalPrintLoopOptions) (finalError error) {
-	re, err := newRepl(ctx, cfg.MaxCompletions, cfg.InitialEnvironment, cfg.HistoryFile)
+	re, err := newRepl(ctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -40,33 +52,67 @@ type repl struct {
 	env            cl.MutableEnv
 	maxCompletions int
 	rliner         *liner.State
+	readLine       func(prompt string) (string, error) // reads answers to confirmation prompts
 	stopped        bool
 	historyFile    string
+	sessionFile    string
+	sources        map[string]string // statements that produced the bindings saved in the session
+	pager          *pager
+	color          *colorizer
+	last           cl.Value // last displayed result, kept for :page
+	results        int      // number of results bound as $_1, $_2, ...
+	transcript     io.WriteCloser
+	policy         *EffectPolicy // nil runs all effects without asking
+	auditLog       io.Writer
 }
 
-func newRepl(
-	ctx context.Context, maxCompletions int, initEnv map[string]cl.Value, historyFile string,
-) (*repl, error) {
+// newEvaluator prepares the parts of the REPL that evaluate statements without a terminal.
+func newEvaluator(cfg ReadEvalPrintLoopOptions) *repl {
+	maxCompletions := cfg.MaxCompletions
 	if maxCompletions == 0 {
 		maxCompletions = 16
 	}
 	env := cl.NewMutableEnv()
-	for k, v := range initEnv {
+	env.Bind("$std", std.Library())
+	for k, v := range cfg.InitialEnvironment {
 		env.Bind(k, v)
 	}
-	rliner := liner.NewLiner()
-	rliner.SetCtrlCAborts(true)
-	rliner.SetTabCompletionStyle(liner.TabPrints)
-	re := &repl{
+	return &repl{
 		maxCompletions: maxCompletions,
-		rliner:         rliner,
 		env:            env,
-		historyFile:    historyFile,
+		sources:        map[string]string{},
 	}
-	rliner.SetWordCompleter(re.newWordCompleter(ctx))
+}
+
+func newRepl(ctx context.Context, cfg ReadEvalPrintLoopOptions) (*repl, error) {
+	re := newEvaluator(cfg)
+	re.pager = newPager() // capture the terminal state before liner changes it
+	re.color = newColorizer()
+	re.historyFile = cfg.HistoryFile
+	re.sessionFile = cfg.SessionFile
+	re.policy = cfg.EffectPolicy
+	re.auditLog = cfg.AuditLog
+	if re.policy == nil {
+		re.policy = &DefaultEffectPolicy
+	}
+	re.rliner = liner.NewLiner()
+	re.readLine = re.rliner.Prompt
+	re.rliner.SetCtrlCAborts(true)
+	re.rliner.SetTabCompletionStyle(liner.TabPrints)
+	re.rliner.SetWordCompleter(re.newWordCompleter(ctx))
 	if err := re.readHistory(); err != nil {
 		return nil, err
 	}
+	if err := re.readSession(ctx); err != nil {
+		return nil, err
+	}
+	if cfg.TranscriptFile != "" {
+		f, err := os.OpenFile(cfg.TranscriptFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening transcript file: %w", err)
+		}
+		re.transcript = f
+	}
 	return re, nil
 }
 
@@ -83,16 +129,19 @@ func (re *repl) interact(ctx context.Context) error {
 		re.rliner.AppendHistory(newCommand)
 		re.prefix = newCommand
 		return nil
-	case err == nil:
-		stmt, err := parser.ParseStmt(command)
-		if err != nil {
-			fmt.Printf("Error invalid syntax: %v\n", err)
-			return nil
+	case err == nil && strings.HasPrefix(strings.TrimSpace(command), ":"):
+		re.color.echo("> ", command)
+		if err := re.command(ctx, strings.TrimSpace(command)); err != nil {
+			return err
 		}
-		if stmt == nil {
-			return nil
+		re.rliner.AppendHistory(command)
+		re.prefix = ""
+		return nil
+	case err == nil:
+		re.color.echo("> ", command)
+		if ok, err := re.execute(ctx, command, false); err != nil || !ok {
+			return err
 		}
-		expr.EvalStmt(ctx, re.env, stmt)
 		re.rliner.AppendHistory(command)
 		re.prefix = ""
 		return nil
@@ -105,15 +154,205 @@ func (re *repl) interact(ctx context.Context) error {
 	}
 }
 
+// eval parses and evaluates a statement, returning the result to display or nil when there is none.
+func (re *repl) eval(ctx context.Context, command string) (cl.Value, error) {
+	stmt, err := parser.ParseStmt(command)
+	if err != nil {
+		return nil, fmt.Errorf("Error invalid syntax: %v", err)
+	}
+	if stmt == nil {
+		return nil, nil
+	}
+	return re.evalStmt(ctx, command, stmt), nil
+}
+
+func (re *repl) evalStmt(ctx context.Context, command string, stmt expr.Stmt) cl.Value {
+	ctx = cl.WithSource(ctx, command)
+	return re.runStmt(ctx, command, stmt, expr.EvalExpr(ctx, re.env, stmtExpr(stmt)))
+}
+
+// runStmt is like evalStmt for a statement whose expression already evaluated to v.
+func (re *repl) runStmt(ctx context.Context, command string, stmt expr.Stmt, v cl.Value) cl.Value {
+	v = expr.RunStmt(ctx, re.env, stmt, v)
+	if v != nil {
+		re.record(v)
+	}
+	switch stmt := stmt.(type) {
+	case *expr.AssignStmt:
+		re.sources[stmt.Ref] = command
+	case *expr.DefStmt:
+		re.sources[stmt.Ref] = command
+	}
+	return v
+}
+
+// execute evaluates a statement and displays its result, first checking its effects against the
+// policy. With preview set the planned effects are always displayed for confirmation. It returns
+// false if the statement does not parse. Interrupting the process with Ctrl-C cancels the statement.
+func (re *repl) execute(ctx context.Context, command string, preview bool) (bool, error) {
+	stmt, err := parser.ParseStmt(command)
+	if err != nil {
+		msg := fmt.Sprintf("Error invalid syntax: %v", err)
+		fmt.Println(re.color.paint(colorRed, msg))
+		return false, re.transcribe(transcriptEntry{command, msg, true})
+	}
+	if stmt == nil {
+		return true, nil
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	if re.policy != nil {
+		ctx = re.policy.limit(ctx)
+	}
+	ctx = re.audit(cl.WithSource(ctx, command), command)
+	// The value that is planned is the value that runs, so that eager methods are called once.
+	v := expr.EvalExpr(ctx, re.env, stmtExpr(stmt))
+	if ok, err := re.authorize(ctx, command, v, preview); err != nil || !ok {
+		return true, err
+	}
+	v = re.runStmt(ctx, command, stmt, v)
+	if v == nil {
+		return true, re.transcribe(transcriptEntry{input: command})
+	}
+	re.print(ctx, command, v)
+	return true, re.transcribe(transcriptEntry{command, render(ctx, command, v), true})
+}
+
+// command executes REPL commands such as :page that are not part of the language.
+func (re *repl) command(ctx context.Context, command string) error {
+	name, arg, _ := strings.Cut(command, " ")
+	switch name {
+	case ":page":
+		if re.last == nil {
+			fmt.Println("No result to page yet")
+			return nil
+		}
+		if err := re.pager.page(cl.ShowFull(ctx, re.last)); err != nil {
+			fmt.Println(err)
+		}
+	case ":plan":
+		_, err := re.execute(ctx, strings.TrimSpace(arg), true)
+		return err
+	case ":fmt":
+		stmt, err := parser.ParseStmt(strings.TrimSpace(arg))
+		switch {
+		case err != nil:
+			fmt.Println(err)
+		case stmt != nil:
+			fmt.Println(re.color.highlight(parser.FormatStmt(stmt)))
+		}
+	case ":show":
+		e, err := parser.ParseExpr(strings.TrimSpace(arg))
+		switch {
+		case err != nil:
+			fmt.Println(err)
+		case e != nil:
+			fmt.Println(describe(ctx, expr.EvalExpr(ctx, re.env, e)))
+		}
+	default:
+		fmt.Printf("Unknown command: %s\n", command)
+	}
+	return nil
+}
+
+// describe renders a value for :show without running it. Functions are described by their signature
+// and documentation rather than their code.
+func describe(ctx context.Context, v cl.Value) string {
+	c, ok := cl.Undefer(v).(cl.Closure)
+	if !ok || c.Name == "" {
+		return cl.ShowFull(ctx, v)
+	}
+	if c.Doc == "" {
+		return c.Signature()
+	}
+	return c.Signature() + "\n  " + c.Doc
+}
+
+// record binds a result to $_ and to the next numbered ref $_1, $_2, ... so that later statements
+// can drill into it. Errors are not recorded so that $_ keeps pointing at the last useful value.
+func (re *repl) record(v cl.Value) {
+	if cl.IsError(v) {
+		return
+	}
+	re.results++
+	re.env.Bind("$_", v)
+	re.env.Bind(fmt.Sprintf("$_%d", re.results), v)
+}
+
+// print displays a result, sending it through the pager when it does not fit on the terminal.
+// Errors are followed by a trace locating the failure in the command.
+func (re *repl) print(ctx context.Context, command string, v cl.Value) {
+	re.last = v
+	defer fmt.Print(re.color.paint(colorGray, errorTrace(command, v)))
+	text := cl.ShowFull(ctx, v)
+	if !re.pager.enabled() || re.pager.fits(text) {
+		fmt.Println(re.color.value(ctx, v, text))
+		return
+	}
+	if err := re.pager.page(text); err != nil {
+		fmt.Println(cl.Show(ctx, v))
+		fmt.Println(err)
+	}
+}
+
+// errorTrace renders the location and the chain of sends that produced an error as lines to print
+// after the error. It is empty for values that are not errors or carry no location. The location is
+// only drawn when the error was raised in command itself rather than, for example, in the body of a
+// function defined earlier.
+func errorTrace(command string, v cl.Value) string {
+	e, ok := cl.AsError(v)
+	if !ok || e.Span == nil {
+		return ""
+	}
+	var sb strings.Builder
+	source := []rune(command)
+	if e.Span.Source == command && e.Span.Offset >= 0 && e.Span.Length > 0 &&
+		e.Span.Offset+e.Span.Length <= len(source) {
+		fmt.Fprintf(&sb, "  at: %s\n      %s%s\n", command,
+			strings.Repeat(" ", e.Span.Offset), strings.Repeat("^", e.Span.Length))
+	}
+	if len(e.Trace) > 1 {
+		fmt.Fprintf(&sb, "  trace: %s", e.Trace[0].Receiver)
+		for _, send := range e.Trace {
+			fmt.Fprintf(&sb, " → %s", send.Message)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// render is the plain text of a result as displayed by the REPL, including error traces.
+func render(ctx context.Context, command string, v cl.Value) string {
+	if trace := errorTrace(command, v); trace != "" {
+		return cl.ShowFull(ctx, v) + "\n" + trace
+	}
+	return cl.ShowFull(ctx, v)
+}
+
+func stmtExpr(stmt expr.Stmt) expr.Expr {
+	switch stmt := stmt.(type) {
+	case *expr.ExprStmt:
+		return stmt.Expr
+	case *expr.AssignStmt:
+		return stmt.Expr
+	case *expr.DefStmt:
+		// Definitions have no effects until called.
+		return &expr.NullExpr{}
+	default:
+		panic(fmt.Sprintf("stmtExpr is incomplete, got %#T", stmt))
+	}
+}
+
 func (re *repl) newWordCompleter(ctx context.Context) liner.WordCompleter {
+	// Positions count runes, like the offsets of queries.
 	return func(line string, pos int) (head string, completions []string, tail string) {
-		line = re.prefix + line
-		pos = len(re.prefix) + pos
+		runes := []rune(re.prefix + line)
+		pos = utf8.RuneCountInString(re.prefix) + pos
 		defer func() { head = strings.TrimPrefix(head, re.prefix) }()
-		head = line[0:pos]
+		head = string(runes[0:pos])
 		completions = []string{}
-		tail = line[pos:]
-		query, err := parser.ParseQuery(line[0:pos])
+		tail = string(runes[pos:])
+		query, err := parser.ParseQuery(head)
 		if err != nil {
 			return
 		}
@@ -122,11 +361,11 @@ func (re *repl) newWordCompleter(ctx context.Context) liner.WordCompleter {
 				return false
 			}
 			if strings.HasPrefix(candidate, query.QueryText()) {
-				completions = append(completions, candidate)
+				completions = append(completions, insertText(query, candidate))
 			}
 			return true
 		})
-		head = line[0:query.Offset()]
+		head = string(runes[0:query.Offset()])
 		return
 	}
 }
@@ -141,15 +380,26 @@ func (re *repl) fuzzyFind(ctx context.Context, command string) (string, error) {
 		candidates = append(candidates, candidate)
 		return true
 	})
+	runes := []rune(command)
 	selected, err := fuzzyfinder.Find(candidates,
 		func(i int) string { return candidates[i] },
-		fuzzyfinder.WithQuery(strings.ReplaceAll(command[query.Offset():], "**", "")))
+		fuzzyfinder.WithQuery(strings.ReplaceAll(string(runes[query.Offset():]), "**", "")))
 	if err != nil && err == fuzzyfinder.ErrAbort {
 		return command, nil
 	} else if err != nil {
 		return "", nil
 	}
-	return fmt.Sprintf("%s %s", command[0:query.Offset()], candidates[selected]), nil
+	return fmt.Sprintf("%s %s", string(runes[0:query.Offset()]),
+		insertText(query, candidates[selected])), nil
+}
+
+// insertText is the source code inserted for a completion candidate. Symbols that are not bare, such
+// as map keys with spaces, are quoted.
+func insertText(query expr.Query, candidate string) string {
+	if _, ok := query.(*expr.SymbolQuery); ok {
+		return expr.QuoteSymbol(candidate)
+	}
+	return candidate
 }
 
 func (re *repl) readHistory() error {
@@ -185,7 +435,11 @@ func (re *repl) writeHistory() error {
 }
 
 func (re *repl) close() error {
-	return errors.Join(re.rliner.Close(), re.writeHistory())
+	err := errors.Join(re.rliner.Close(), re.writeHistory(), re.writeSession())
+	if re.transcript != nil {
+		err = errors.Join(err, re.transcript.Close())
+	}
+	return err
 }
 
 func (re *repl) active() bool {
@@ -0,0 +1,180 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cl "github.com/t0yv0/complang"
+)
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$m": cl.MapValue{"a": cl.StringValue{Text: "1"}}},
+	})
+	lookup := func(ref string) string {
+		v, ok := re.env.Lookup(ref)
+		if !ok {
+			return "unbound"
+		}
+		return cl.Show(ctx, v)
+	}
+	for _, command := range []string{`$m a`, `$x = two`, `$m b`, `three`} {
+		_, err := re.eval(ctx, command)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "1", lookup("$_1"))
+	assert.Equal(t, "three", lookup("$_2"), "assignments and errors are not recorded")
+	assert.Equal(t, "three", lookup("$_"))
+	assert.Equal(t, "unbound", lookup("$_3"))
+	assert.Equal(t, "two", lookup("$x"))
+}
+
+type countingTarget struct{ calls *int }
+
+func (c countingTarget) Count() int {
+	*c.calls++
+	return *c.calls
+}
+
+func TestExecuteEvaluatesOnce(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$obj": cl.BindValue(countingTarget{&calls})},
+	})
+	re.color = &colorizer{}
+	re.pager = fakeTerminal(0)
+	re.policy = &ReadOnlyEffectPolicy
+	ok, err := re.execute(ctx, `$n = $obj Count`, false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, calls, "planning must not call methods again")
+	n, _ := re.env.Lookup("$n")
+	assert.Equal(t, "1", cl.Show(ctx, n))
+}
+
+type auditTarget struct{}
+
+func (auditTarget) Users() auditTarget { return auditTarget{} }
+
+func (auditTarget) Rename(from, to string) string { return to }
+
+func (auditTarget) Delete(name string) string { return name }
+
+func (auditTarget) Compact() string { return "compacted" }
+
+func (auditTarget) ComplangEffect(method string) cl.Effect {
+	switch method {
+	case "Delete":
+		return cl.DestructiveEffect
+	case "Compact":
+		return cl.WriteEffect
+	}
+	return cl.UndeclaredEffect
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	var log bytes.Buffer
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$db": cl.BindValue(auditTarget{})},
+	})
+	re.color = &colorizer{}
+	re.pager = fakeTerminal(0)
+	re.auditLog = &log
+	records := func() []auditRecord {
+		var rs []auditRecord
+		dec := json.NewDecoder(&log)
+		for dec.More() {
+			var r auditRecord
+			require.NoError(t, dec.Decode(&r))
+			rs = append(rs, r)
+		}
+		return rs
+	}
+
+	_, err := re.execute(ctx, `$db Users Rename a b`, false)
+	require.NoError(t, err)
+	rs := records()
+	require.Len(t, rs, 1)
+	assert.Equal(t, "$db Users Rename", rs[0].Receiver)
+	assert.Equal(t, []string{"a", "b"}, rs[0].Args)
+	assert.Equal(t, "b", rs[0].Result)
+
+	// Methods without arguments are audited when they declare effects.
+	_, err = re.execute(ctx, `$db Compact`, false)
+	require.NoError(t, err)
+	rs = records()
+	require.Len(t, rs, 1)
+	assert.Equal(t, "$db Compact", rs[0].Receiver)
+	assert.Equal(t, "compacted", rs[0].Result)
+
+	re.policy = &ReadOnlyEffectPolicy
+	_, err = re.execute(ctx, `$db Delete a`, false)
+	require.NoError(t, err)
+	rs = records()
+	require.Len(t, rs, 1)
+	assert.Equal(t, `$db Delete a`, rs[0].Statement)
+	assert.Equal(t, "destructive", rs[0].Effect)
+	assert.Equal(t, "refused: destructive effects are not allowed", rs[0].Error)
+}
+
+func TestAuthorizeWithoutTarget(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$wipe": cl.Closure{
+			Call: func(context.Context, cl.Env) cl.Value {
+				calls++
+				return cl.NullValue{}
+			},
+			Effect: cl.DestructiveEffect,
+		}},
+	})
+	re.color = &colorizer{}
+	re.pager = fakeTerminal(0)
+	re.policy = &DefaultEffectPolicy
+	var prompts []string
+	answer := ""
+	re.readLine = func(prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		return answer, nil
+	}
+
+	// The closure has neither a name nor arguments to type, so pressing Enter must not confirm it.
+	_, err := re.execute(ctx, `$wipe`, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, []string{"Run destructive effects without a named target? [y/N] "}, prompts)
+
+	answer = "y"
+	_, err = re.execute(ctx, `$wipe`, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestErrorTrace(t *testing.T) {
+	ctx := context.Background()
+	re := newEvaluator(ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$m": cl.MapValue{}},
+	})
+	for _, command := range []string{`def $f = $m missing`, `$g = [$x | $m $x]`} {
+		_, err := re.eval(ctx, command)
+		require.NoError(t, err)
+	}
+	for command, expected := range map[string]string{
+		`$m x`: "  at: $m x\n         ^\n",
+		// The errors are raised in earlier statements, whose offsets do not apply here.
+		`$f # long enough to draw a caret`:       "",
+		`$g other # long enough to draw a caret`: "",
+	} {
+		v, err := re.eval(ctx, command)
+		require.NoError(t, err)
+		assert.Equal(t, expected, errorTrace(command, cl.Run(ctx, v)), command)
+	}
+}
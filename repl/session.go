@@ -0,0 +1,207 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	cl "github.com/t0yv0/complang"
+	"github.com/t0yv0/complang/expr"
+	"github.com/t0yv0/complang/parser"
+)
+
+// sessionFile is the on-disk format of the bindings made in a REPL session.
+type sessionFile struct {
+	Bindings []sessionBinding `json:"bindings"`
+}
+
+// sessionBinding stores either the value of a binding, when it can be serialized, or the
+// statement that produced it.
+type sessionBinding struct {
+	Ref   string `json:"ref"`
+	Value any    `json:"value,omitempty"`
+	Stmt  string `json:"stmt,omitempty"`
+}
+
+// readSession restores bindings saved by writeSession. Functions are restored by evaluating their def
+// statements, which has no effects. Other statements are bound to lazy values that re-evaluate them
+// on first use, limited to the effects that the policy runs without asking.
+func (re *repl) readSession(ctx context.Context) error {
+	if re.sessionFile == "" {
+		return nil
+	}
+	f, err := os.Open(re.sessionFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error opening session file: %w", err)
+	}
+	defer f.Close()
+	var sf sessionFile
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&sf); err != nil {
+		return fmt.Errorf("Error reading session file: %w", err)
+	}
+	for _, b := range sf.Bindings {
+		if b.Stmt == "" {
+			re.env.Bind(b.Ref, decodeSessionValue(b.Value))
+			re.sources[b.Ref] = ""
+			continue
+		}
+		if def, ok := parseDef(b.Stmt); ok {
+			re.env.Bind(b.Ref, expr.EvalDef(cl.WithSource(ctx, b.Stmt), re.env, def))
+		} else {
+			re.env.Bind(b.Ref, re.lazyStmtValue(ctx, b.Stmt))
+		}
+		re.sources[b.Ref] = b.Stmt
+	}
+	return nil
+}
+
+// writeSession saves the bindings made in the session: plain data as values, and other values as the
+// statements that produced them.
+func (re *repl) writeSession() error {
+	if re.sessionFile == "" {
+		return nil
+	}
+	var refs []string
+	for ref := range re.sources {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	sf := sessionFile{Bindings: []sessionBinding{}}
+	for _, ref := range refs {
+		v, ok := re.env.Lookup(ref)
+		if !ok {
+			continue
+		}
+		if x, ok := encodeSessionValue(v); ok {
+			sf.Bindings = append(sf.Bindings, sessionBinding{Ref: ref, Value: x})
+		} else if re.sources[ref] != "" {
+			sf.Bindings = append(sf.Bindings, sessionBinding{Ref: ref, Stmt: re.sources[ref]})
+		}
+	}
+	bytes, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding session: %w", err)
+	}
+	if err := os.WriteFile(re.sessionFile, bytes, 0600); err != nil {
+		return fmt.Errorf("Error writing session file: %w", err)
+	}
+	return nil
+}
+
+// lazyStmtValue re-evaluates an assignment saved in a session when its value is first used. Effects
+// that the policy would ask about or refuse are not run, since the user did not enter the statement;
+// the value is then an error asking to run the statement again.
+func (re *repl) lazyStmtValue(ctx context.Context, source string) cl.Value {
+	return cl.LazyValue(func() cl.Value {
+		stmt, err := parser.ParseStmt(source)
+		assign, ok := stmt.(*expr.AssignStmt)
+		if err != nil || !ok {
+			return cl.Error{ErrorMessage: fmt.Sprintf("cannot restore %q from the session", source)}
+		}
+		ctx := re.audit(cl.WithSource(ctx, source), source)
+		if re.policy != nil {
+			ctx = re.policy.unattended(ctx)
+		}
+		v := expr.EvalExpr(ctx, re.env, assign.Expr)
+		effect := cl.StrongestEffect(cl.Plan(ctx, v))
+		if re.policy != nil && re.policy.action(effect) != AllowEffect {
+			return cl.Error{
+				ErrorMessage: fmt.Sprintf("not restoring %s with %s effects, run it again: %s",
+					assign.Ref, effect, source),
+				Code: cl.EffectRefusedCode,
+			}
+		}
+		return cl.Run(ctx, v)
+	})
+}
+
+// parseDef parses the source of a def statement.
+func parseDef(source string) (*expr.DefStmt, bool) {
+	stmt, err := parser.ParseStmt(source)
+	if err != nil {
+		return nil, false
+	}
+	def, ok := stmt.(*expr.DefStmt)
+	return def, ok
+}
+
+// encodeSessionValue converts plain data values to JSON. Closures and other values that cannot be
+// serialized are rejected.
+func encodeSessionValue(v cl.Value) (any, bool) {
+	switch v := v.(type) {
+	case cl.NullValue:
+		return nil, true
+	case cl.BoolValue:
+		return v.Bool, true
+	case cl.StringValue:
+		return v.Text, true
+	case cl.NumValue:
+		// Fractions such as 1/3 have no exact JSON representation.
+		text := cl.Show(context.Background(), v)
+		if strings.Contains(text, "/") {
+			return nil, false
+		}
+		return json.Number(text), true
+	case cl.SliceValue:
+		result := []any{}
+		for _, e := range v {
+			x, ok := encodeSessionValue(e)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, x)
+		}
+		return result, true
+	case cl.MapValue:
+		result := map[string]any{}
+		for k, e := range v {
+			x, ok := encodeSessionValue(e)
+			if !ok {
+				return nil, false
+			}
+			result[k] = x
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+func decodeSessionValue(x any) cl.Value {
+	switch x := x.(type) {
+	case nil:
+		return cl.NullValue{}
+	case bool:
+		return cl.BoolValue{Bool: x}
+	case string:
+		return cl.StringValue{Text: x}
+	case json.Number:
+		n, err := cl.ParseNum(string(x))
+		if err != nil {
+			return cl.Error{ErrorMessage: fmt.Sprintf("invalid number in session: %s", x)}
+		}
+		return n
+	case []any:
+		result := cl.SliceValue{}
+		for _, e := range x {
+			result = append(result, decodeSessionValue(e))
+		}
+		return result
+	case map[string]any:
+		result := cl.MapValue{}
+		for k, e := range x {
+			result[k] = decodeSessionValue(e)
+		}
+		return result
+	default:
+		return cl.Error{ErrorMessage: fmt.Sprintf("unexpected value in session: %v", x)}
+	}
+}
@@ -0,0 +1,108 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cl "github.com/t0yv0/complang"
+)
+
+func TestSessionValues(t *testing.T) {
+	ctx := context.Background()
+	for _, v := range []cl.Value{
+		cl.NullValue{},
+		cl.BoolValue{Bool: true},
+		cl.StringValue{Text: "ü"},
+		cl.NumValue{Num: big.NewRat(-5, 4)},
+		cl.NumValue{Num: new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), 100))},
+		cl.SliceValue{cl.StringValue{Text: "a"}, cl.SliceValue{}},
+		cl.MapValue{"k": cl.MapValue{"n": cl.NullValue{}}},
+	} {
+		x, ok := encodeSessionValue(v)
+		require.True(t, ok, cl.Show(ctx, v))
+		bytes, err := json.Marshal(x)
+		require.NoError(t, err)
+		var decoded any
+		dec := json.NewDecoder(strings.NewReader(string(bytes)))
+		dec.UseNumber()
+		require.NoError(t, dec.Decode(&decoded))
+		assert.Equal(t, cl.ShowFull(ctx, v), cl.ShowFull(ctx, decodeSessionValue(decoded)))
+	}
+	for _, v := range []cl.Value{
+		cl.NumValue{Num: big.NewRat(1, 3)},
+		cl.Closure{},
+		cl.MapValue{"f": cl.Closure{}},
+	} {
+		_, ok := encodeSessionValue(v)
+		assert.False(t, ok, cl.Show(ctx, v))
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	reads, writes := 0, 0
+	counter := func(calls *int, effect cl.Effect) cl.Value {
+		return cl.Closure{
+			Call: func(context.Context, cl.Env) cl.Value {
+				*calls++
+				return cl.Closure{Params: []string{"$x"}}
+			},
+			Name:   "counter",
+			Effect: effect,
+		}
+	}
+	cfg := ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{
+			"$read":  counter(&reads, cl.ReadEffect),
+			"$write": counter(&writes, cl.WriteEffect),
+		},
+	}
+	re := newEvaluator(cfg)
+	re.sessionFile = filepath.Join(t.TempDir(), "session.json")
+	for _, command := range []string{
+		`$data = "text"`,
+		`def $twice $x "Doubles." = $x + $x`,
+		`$r = $read`,
+		`$w = $write`,
+	} {
+		_, err := re.eval(ctx, command)
+		require.NoError(t, err)
+	}
+	require.NoError(t, re.writeSession())
+
+	restore := func(policy *EffectPolicy) *repl {
+		restored := newEvaluator(cfg)
+		restored.sessionFile = re.sessionFile
+		restored.policy = policy
+		require.NoError(t, restored.readSession(ctx))
+		return restored
+	}
+	restored := restore(&DefaultEffectPolicy)
+	assert.Equal(t, [2]int{1, 1}, [2]int{reads, writes}, "restoring must not evaluate statements")
+	for command, expected := range map[string]string{
+		`$data`:        "text",
+		`$twice $data`: "texttext",
+		`$r`:           "<Closure:$x>",
+		`$w`:           "ERROR: not restoring $w with write effects, run it again: $w = $write",
+	} {
+		for i := 0; i < 2; i++ {
+			v, err := restored.eval(ctx, command)
+			require.NoError(t, err)
+			assert.Equal(t, expected, cl.Show(ctx, v), command)
+		}
+	}
+	assert.Equal(t, [2]int{2, 1}, [2]int{reads, writes}, "statements are evaluated once on first use")
+
+	// Statements are saved again even if they were not used.
+	require.NoError(t, restored.writeSession())
+	restored = restore(nil)
+	_, err := restored.eval(ctx, `$w`)
+	require.NoError(t, err)
+	assert.Equal(t, 2, writes, "without a policy all effects run")
+}
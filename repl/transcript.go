@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	cl "github.com/t0yv0/complang"
+	"github.com/t0yv0/complang/expr"
+	"github.com/t0yv0/complang/parser"
+)
+
+// Transcripts are plain text: every statement is written on a line starting with "> " and is
+// followed by the lines of its rendered output, as the REPL displays them without colors. Output lines
+// that start with ">" or with the escape character "\" are escaped with a "\", so that they are not
+// mistaken for statements. An output that is the empty string, such as the value of "", is written as
+// a line holding only the escape character, to tell it apart from no output.
+const (
+	transcriptPrompt = "> "
+	transcriptEscape = "\\"
+)
+
+type transcriptEntry struct {
+	input  string
+	output string
+	// hasOutput tells an output that is the empty string apart from no output.
+	hasOutput bool
+}
+
+// transcribe records a statement and its output in the transcript file, if one is configured.
+func (re *repl) transcribe(e transcriptEntry) error {
+	if re.transcript == nil {
+		return nil
+	}
+	if _, err := fmt.Fprint(re.transcript, formatTranscriptEntry(e)); err != nil {
+		return fmt.Errorf("Error writing transcript: %w", err)
+	}
+	return nil
+}
+
+// ReplayTranscript re-runs the statements of a transcript recorded through the TranscriptFile option
+// against a fresh REPL configured by cfg. It returns a unified diff between the recorded and the
+// actual outputs, which is empty when they agree. This makes it possible to write golden tests for
+// Go values bound into complang as ordinary REPL sessions. Statements are not confirmed, but those
+// that the EffectPolicy of cfg refuses are refused again.
+func ReplayTranscript(ctx context.Context, cfg ReadEvalPrintLoopOptions, transcript string) (string, error) {
+	re := newEvaluator(cfg)
+	re.policy = cfg.EffectPolicy
+	var expected, actual strings.Builder
+	for _, e := range parseTranscript(transcript) {
+		expected.WriteString(formatTranscriptEntry(e))
+		actual.WriteString(formatTranscriptEntry(re.replay(ctx, e.input)))
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected.String()),
+		B:        difflib.SplitLines(actual.String()),
+		FromFile: "recorded",
+		ToFile:   "replayed",
+		Context:  3,
+	})
+}
+
+// replay evaluates a statement of a transcript like execute, but without asking for confirmation.
+func (re *repl) replay(ctx context.Context, command string) transcriptEntry {
+	stmt, err := parser.ParseStmt(command)
+	if err != nil {
+		return transcriptEntry{command, fmt.Sprintf("Error invalid syntax: %v", err), true}
+	}
+	if stmt == nil {
+		return transcriptEntry{input: command}
+	}
+	if re.policy != nil {
+		ctx = re.policy.limit(ctx)
+	}
+	ctx = cl.WithSource(ctx, command)
+	v := expr.EvalExpr(ctx, re.env, stmtExpr(stmt))
+	if re.policy != nil {
+		effect := cl.StrongestEffect(cl.Plan(ctx, v))
+		if re.policy.action(effect) == RefuseEffect {
+			return transcriptEntry{command, refusal(effect), true}
+		}
+	}
+	if v = re.runStmt(ctx, command, stmt, v); v == nil {
+		return transcriptEntry{input: command}
+	}
+	return transcriptEntry{command, render(ctx, command, v), true}
+}
+
+func formatTranscriptEntry(e transcriptEntry) string {
+	if !e.hasOutput {
+		return transcriptPrompt + e.input + "\n"
+	}
+	output := strings.TrimSuffix(e.output, "\n")
+	if output == "" {
+		return transcriptPrompt + e.input + "\n" + transcriptEscape + "\n"
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") || strings.HasPrefix(line, transcriptEscape) {
+			lines[i] = transcriptEscape + line
+		}
+	}
+	return transcriptPrompt + e.input + "\n" + strings.Join(lines, "\n") + "\n"
+}
+
+func parseTranscript(transcript string) []transcriptEntry {
+	var entries []transcriptEntry
+	for _, line := range strings.Split(strings.TrimSuffix(transcript, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, transcriptPrompt):
+			entries = append(entries, transcriptEntry{input: strings.TrimPrefix(line, transcriptPrompt)})
+		case len(entries) > 0:
+			line = strings.TrimPrefix(line, transcriptEscape)
+			e := &entries[len(entries)-1]
+			if e.hasOutput {
+				e.output += "\n"
+			}
+			e.output += line
+			e.hasOutput = true
+		}
+	}
+	return entries
+}
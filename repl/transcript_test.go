@@ -0,0 +1,118 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cl "github.com/t0yv0/complang"
+)
+
+func TestReplayTranscript(t *testing.T) {
+	ctx := context.Background()
+	cfg := ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{
+			"$digits": cl.MapValue(map[string]cl.Value{
+				"one":   cl.StringValue{Text: "1"},
+				"two":   cl.StringValue{Text: "2"},
+				"three": cl.StringValue{Text: "3"},
+			}),
+		},
+	}
+	transcript, err := os.ReadFile("testdata/digits.transcript")
+	require.NoError(t, err)
+
+	t.Run("matching", func(t *testing.T) {
+		diff, err := ReplayTranscript(ctx, cfg, string(transcript))
+		require.NoError(t, err)
+		assert.Empty(t, diff)
+	})
+
+	t.Run("mismatching", func(t *testing.T) {
+		diff, err := ReplayTranscript(ctx, cfg, "> $digits one\n11\n")
+		require.NoError(t, err)
+		assert.Contains(t, diff, "-11\n+1\n")
+	})
+}
+
+func TestTranscriptEscapes(t *testing.T) {
+	e := transcriptEntry{`$x`, "> looks like input\n\\ starts with a backslash\nplain", true}
+	text := formatTranscriptEntry(e)
+	assert.Equal(t, "> $x\n\\> looks like input\n\\\\ starts with a backslash\nplain\n", text)
+	assert.Equal(t, []transcriptEntry{e}, parseTranscript(text))
+}
+
+func TestTranscriptBlankOutput(t *testing.T) {
+	for _, c := range []struct {
+		entry transcriptEntry
+		text  string
+	}{
+		{transcriptEntry{input: `$x = ""`}, "> $x = \"\"\n"},
+		{transcriptEntry{`$x`, "", true}, "> $x\n\\\n"},
+		{transcriptEntry{`$x`, "\nafter a blank line", true}, "> $x\n\nafter a blank line\n"},
+	} {
+		text := formatTranscriptEntry(c.entry)
+		assert.Equal(t, c.text, text)
+		assert.Equal(t, []transcriptEntry{c.entry}, parseTranscript(text))
+	}
+
+	ctx := context.Background()
+	transcript := "> $empty = \"\"\n> $empty\n\\\n"
+	diff, err := ReplayTranscript(ctx, ReadEvalPrintLoopOptions{}, transcript)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
+
+func TestTranscriptRefusals(t *testing.T) {
+	ctx := context.Background()
+	var transcript strings.Builder
+	calls := 0
+	cfg := ReadEvalPrintLoopOptions{
+		InitialEnvironment: map[string]cl.Value{"$write": cl.Closure{
+			Call: func(context.Context, cl.Env) cl.Value {
+				calls++
+				return cl.NullValue{}
+			},
+			Name:   "write",
+			Effect: cl.WriteEffect,
+		}},
+		EffectPolicy: &ReadOnlyEffectPolicy,
+	}
+	re := newEvaluator(cfg)
+	re.color = &colorizer{}
+	re.pager = fakeTerminal(0)
+	re.policy = cfg.EffectPolicy
+	re.transcript = nopWriteCloser{&transcript}
+	_, err := re.execute(ctx, `$write`, false)
+	require.NoError(t, err)
+	assert.Equal(t, "> $write\nRefused: write effects are not allowed\n", transcript.String())
+
+	diff, err := ReplayTranscript(ctx, cfg, transcript.String())
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+	assert.Equal(t, 0, calls)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestTranscriptRecordsFullOutput(t *testing.T) {
+	ctx := context.Background()
+	long := cl.MapValue{}
+	for i := 0; i < 100; i++ {
+		long[fmt.Sprintf("key%d", i)] = cl.StringValue{Text: strings.Repeat("x", 100)}
+	}
+	cfg := ReadEvalPrintLoopOptions{InitialEnvironment: map[string]cl.Value{"$long": long}}
+	require.NotEqual(t, cl.Show(ctx, long), cl.ShowFull(ctx, long))
+
+	transcript := formatTranscriptEntry(transcriptEntry{"$long", cl.ShowFull(ctx, long), true})
+	diff, err := ReplayTranscript(ctx, cfg, transcript)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
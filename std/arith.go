@@ -0,0 +1,33 @@
+package std
+
+import (
+	"context"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// operators maps $std function names to the messages they send, so that custom values can
+// overload them by responding to the operator message.
+//
+//	$std add 1 2
+//	$std lt ($xs size) 10
+var operators = map[string]string{
+	"add": "+",
+	"sub": "-",
+	"mul": "*",
+	"div": "/",
+	"mod": "mod",
+	"eq":  "==",
+	"ne":  "!=",
+	"lt":  "<",
+	"gt":  ">",
+	"le":  "<=",
+	"ge":  ">=",
+}
+
+func operator(op string) func(ctx context.Context, args []cl.Value) cl.Value {
+	return func(ctx context.Context, args []cl.Value) cl.Value {
+		x := cl.Force(ctx, args[0])
+		return x.Message(ctx, cl.StringValue{Text: op}).Message(ctx, args[1])
+	}
+}
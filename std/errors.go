@@ -0,0 +1,79 @@
+package std
+
+import (
+	"context"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// try returns value, or if value is an error, the result of sending a map describing the error to
+// handler. The map has "message" and "code" keys.
+//
+//	$std try ($m key) [$e | $e message]
+func try(ctx context.Context, args []cl.Value) cl.Value {
+	value, handler := args[0], args[1]
+	return recoverWith(ctx, value, func(ctx context.Context, err cl.Error) cl.Value {
+		return handler.Message(ctx, errorInfo(err))
+	})
+}
+
+// orElse returns value, or fallback if value is an error.
+//
+//	$std orElse ($m key) "default"
+func orElse(ctx context.Context, args []cl.Value) cl.Value {
+	value, fallback := args[0], args[1]
+	return recoverWith(ctx, value, func(context.Context, cl.Error) cl.Value {
+		return fallback
+	})
+}
+
+func recoverWith(
+	ctx context.Context, value cl.Value, recover func(context.Context, cl.Error) cl.Value,
+) cl.Value {
+	if isDeferred(value) {
+		return deferredRecover{value: value, recover: recover}
+	}
+	if err, ok := cl.AsError(value); ok {
+		return recover(ctx, err)
+	}
+	return value
+}
+
+// isDeferred checks for closures that only produce their result, and possibly an error, when run.
+func isDeferred(v cl.Value) bool {
+	c, ok := v.(cl.Closure)
+	return ok && len(c.Params) == 0 && !c.IsPure
+}
+
+// deferredRecover recovers from errors raised when running a deferred value.
+type deferredRecover struct {
+	value   cl.Value
+	recover func(context.Context, cl.Error) cl.Value
+}
+
+func (d deferredRecover) Message(ctx context.Context, msg cl.Value) cl.Value {
+	switch msg := msg.(type) {
+	case cl.ShowMessage:
+		return cl.StringValue{Text: "<$std try>"}
+	case cl.RunMessage:
+		v := cl.Run(ctx, d.value)
+		if err, ok := cl.AsError(v); ok {
+			return cl.Run(ctx, d.recover(ctx, err))
+		}
+		return v
+	case cl.PlanRequest:
+		for _, step := range cl.Plan(ctx, d.value) {
+			msg.Receiver(step)
+		}
+		return cl.NullValue{}
+	default:
+		return cl.DoNotUnderstandError(ctx, d, msg)
+	}
+}
+
+func errorInfo(err cl.Error) cl.Value {
+	return cl.MapValue{
+		"message": cl.StringValue{Text: err.ErrorMessage},
+		"code":    cl.StringValue{Text: err.Code},
+	}
+}
@@ -0,0 +1,68 @@
+package std
+
+import (
+	"context"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// ifThenElse evaluates one of two branches depending on a condition. Branches are usually lambda
+// blocks so that only the chosen one is evaluated.
+//
+//	$std if ($x not) [a] [b]
+func ifThenElse(ctx context.Context, args []cl.Value) cl.Value {
+	cond, err := toBool(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if cond {
+		return cl.Tail(args[1])
+	}
+	return cl.Tail(args[2])
+}
+
+// and returns false without evaluating the second operand if the first one is false.
+//
+//	$std and $x [$y]
+func and(ctx context.Context, args []cl.Value) cl.Value {
+	x, err := toBool(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !x {
+		return cl.BoolValue{Bool: false}
+	}
+	return cl.ToBool(ctx, args[1])
+}
+
+// or returns true without evaluating the second operand if the first one is true.
+//
+//	$std or $x [$y]
+func or(ctx context.Context, args []cl.Value) cl.Value {
+	x, err := toBool(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if x {
+		return cl.BoolValue{Bool: true}
+	}
+	return cl.ToBool(ctx, args[1])
+}
+
+func not(ctx context.Context, args []cl.Value) cl.Value {
+	x, err := toBool(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return cl.BoolValue{Bool: !x}
+}
+
+// toBool forces v to a boolean, returning the error value to propagate if it is not one.
+func toBool(ctx context.Context, v cl.Value) (bool, cl.Value) {
+	switch b := cl.ToBool(ctx, v).(type) {
+	case cl.BoolValue:
+		return b.Bool, nil
+	default:
+		return false, b
+	}
+}
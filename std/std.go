@@ -0,0 +1,52 @@
+package std
+
+import (
+	"context"
+	"fmt"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// Library returns the standard library, conventionally bound to $std.
+func Library() cl.Value {
+	lib := cl.MapValue{
+		"try":      newFunction("try", []string{"value", "handler"}, try),
+		"orElse":   newFunction("orElse", []string{"value", "default"}, orElse),
+		"if":       newFunction("if", []string{"cond", "then", "else"}, ifThenElse),
+		"and":      newFunction("and", []string{"x", "y"}, and),
+		"or":       newFunction("or", []string{"x", "y"}, or),
+		"not":      newFunction("not", []string{"x"}, not),
+		"template": newFunction("template", []string{"text", "data"}, renderTemplate),
+		"apply":    newFunction("apply", []string{"f", "args"}, apply),
+	}
+	for name, op := range operators {
+		lib[name] = newFunction(name, []string{"x", "y"}, operator(op))
+	}
+	return lib
+}
+
+// newFunction makes the $std function of the given name.
+func newFunction(
+	name string, params []string, call func(ctx context.Context, args []cl.Value) cl.Value,
+) cl.Value {
+	return cl.Builtin{Name: "$std " + name, Params: params, Call: call}
+}
+
+// apply applies a function to a list of arguments. Passing more arguments than the function takes is
+// an error.
+//
+//	$std apply $f $args
+func apply(ctx context.Context, args []cl.Value) cl.Value {
+	v := cl.Force(ctx, args[1])
+	list, ok := v.(cl.SliceValue)
+	switch {
+	case cl.IsError(v):
+		return v
+	case !ok:
+		return cl.Error{
+			ErrorMessage: fmt.Sprintf("expected a list of arguments, got %s", cl.Show(ctx, args[1])),
+			Code:         cl.TypeErrorCode,
+		}
+	}
+	return cl.Apply(ctx, args[0], list)
+}
@@ -0,0 +1,155 @@
+package std
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cl "github.com/t0yv0/complang"
+	"github.com/t0yv0/complang/expr"
+	"github.com/t0yv0/complang/parser"
+)
+
+// newEnv binds $std and $m.
+func newEnv() cl.MutableEnv {
+	env := cl.NewMutableEnv()
+	env.Bind("$std", Library())
+	env.Bind("$m", cl.MapValue{
+		"a": cl.StringValue{Text: "1"},
+		"b": cl.StringValue{Text: "2"},
+	})
+	return env
+}
+
+// eval runs code in env as the REPL would.
+func eval(t *testing.T, ctx context.Context, env cl.MutableEnv, code string) string {
+	stmt, err := parser.ParseStmt(code)
+	require.NoError(t, err)
+	return cl.Show(ctx, expr.EvalStmt(ctx, env, stmt))
+}
+
+func TestErrorHandling(t *testing.T) {
+	for code, expected := range map[string]string{
+		`$std try ($m a) [$e | $e message]`:         "1",
+		`$std try ($m c) [$e | $e code]`:            cl.DoesNotUnderstandCode,
+		`$std try ($m c) [$e | $e message]`:         "object map{a, b} does not understand c",
+		`$std try [$m c] [$e | $e code]`:            cl.DoesNotUnderstandCode,
+		`$std orElse ($m a) fallback`:               "1",
+		`$std orElse ($m c) fallback`:               "fallback",
+		`$std orElse ($std orElse ($m c) ($m d)) b`: "b",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, context.Background(), newEnv(), code))
+		})
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	for code, expected := range map[string]string{
+		`$std if true [$m a] [$m c]`:         "1",
+		`$std if (true not) [$m c] [$m b]`:   "2",
+		`$std if $m [$m a] [$m b]`:           "ERROR: expected a bool, got map{a, b}",
+		`$std and false [$m c]`:              "false",
+		`$std or ($std not true) [true]`:     "true",
+		`$std if ($std and true [true]) a b`: "a",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, context.Background(), newEnv(), code))
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	for code, expected := range map[string]string{
+		`$std add 1 2`:                     "3",
+		`$std sub 0.3 0.1`:                 "0.2",
+		`$std mul 1.5 4`:                   "6",
+		`$std div 1 3`:                     "1/3",
+		`$std div 1 8`:                     "0.125",
+		`$std div 1 0`:                     "ERROR: division of 1 by zero",
+		`$std mod 7 3`:                     "1",
+		`$std mod -7 3`:                    "2",
+		`$std mod 7.5 2`:                   "1.5",
+		`$std mul 12345678901234567890 10`: "123456789012345678900",
+		`$std eq 2 2.0`:                    "true",
+		`$std ne 2 a`:                      "true",
+		`$std lt 1 2`:                      "true",
+		`$std ge 1 2`:                      "false",
+		`$std lt 1 a`:                      "ERROR: expected a number, got a",
+		`$std add ($std div 1 3) [1]`:      "4/3",
+		`$std if ($std lt 1 2) [yes] [no]`: "yes",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, context.Background(), newEnv(), code))
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	env := newEnv()
+	env.Bind("$args", cl.SliceValue{cl.NumValue{Num: big.NewRat(7, 1)}, cl.NumValue{Num: big.NewRat(2, 1)}})
+	for code, expected := range map[string]string{
+		`$std apply ($std sub) $args`:                     "5",
+		`$std apply [$x $y | $x * $y] $args`:              "14",
+		`$std apply ($std sub 1) $args`:                   "ERROR: <$std sub:y> takes 1 argument, got 2",
+		`$std apply [$x | $x] $args`:                      "ERROR: <Closure:$x> takes 1 argument, got 2",
+		`$std try ($std apply $std $args) [$e | $e code]`: "does-not-understand",
+		`$std apply ($std add) 1`:                         "ERROR: expected a list of arguments, got 1",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, ctx, env, code))
+		})
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	ctx := cl.WithMaxDepth(context.Background(), 1000)
+	env := newEnv()
+	for _, code := range []string{
+		`def $count $n = ($n > 0) then [$count ($n - 1)] else [done]`,
+		`def $loop $n = $std if ($n > 0) [$loop ($n - 1)] [done]`,
+		`def $sum $n = ($n <= 0) then [0] else [$n + ($sum ($n - 1))]`,
+	} {
+		stmt, err := parser.ParseStmt(code)
+		require.NoError(t, err)
+		require.Nil(t, expr.EvalStmt(ctx, env, stmt))
+	}
+	for code, expected := range map[string]string{
+		`$loop 20000`:                         "done",
+		`$std orElse ($count 10000) none`:     "done",
+		`$std try ($sum 1000) [$e | $e code]`: "max-depth",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, ctx, env, code))
+		})
+	}
+}
+
+func TestPipelines(t *testing.T) {
+	for code, expected := range map[string]string{
+		`$m |> [$x | $x c] |> $std orElse none`: "none",
+		`$std div 1 2 |> $std add 1`:            "1.5",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, context.Background(), newEnv(), code))
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	for code, expected := range map[string]string{
+		`$std template "a={{.a}} b={{.b}}" $m`:        "a=1 b=2",
+		`$std template "{{if gt . 1}}big{{end}}" 2`:   "big",
+		`$std template "{{.c}}" $m`:                   `ERROR: template: template:1:2: executing "template" at <.c>: map has no entry for key "c"`,
+		`$std template "{{range .}}{{.}},{{end}}" $m`: "1,2,",
+		`$m |> [$x | $std template "{{len .}}" $x]`:   "2",
+		`$std template "{{" $m`:                       "ERROR: template: template:1: unclosed action",
+	} {
+		t.Run(code, func(t *testing.T) {
+			assert.Equal(t, expected, eval(t, context.Background(), newEnv(), code))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package std
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	cl "github.com/t0yv0/complang"
+)
+
+// renderTemplate renders a Go text/template against data, typically a map of explored values.
+//
+//	$std template "{{range .}}{{.name}}: {{.size}}\n{{end}}" $files
+func renderTemplate(ctx context.Context, args []cl.Value) cl.Value {
+	arg := cl.Force(ctx, args[0])
+	text, ok := arg.(cl.StringValue)
+	if !ok {
+		if cl.IsError(arg) {
+			return arg
+		}
+		return cl.Error{
+			ErrorMessage: fmt.Sprintf("expected a template string, got %s", cl.Show(ctx, arg)),
+			Code:         cl.TypeErrorCode,
+		}
+	}
+	data, err := templateData(ctx, cl.Force(ctx, args[1]))
+	if err != nil {
+		return err
+	}
+	t, perr := template.New("template").Option("missingkey=error").Parse(text.Text)
+	if perr != nil {
+		return cl.BindValue(perr)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return cl.BindValue(err)
+	}
+	return cl.StringValue{Text: sb.String()}
+}
+
+// templateData converts plain data values to Go values for templates. Integers become int64 when
+// they fit so that template comparisons work; other numbers, closures and opaque values are
+// rendered with cl.Show. Closures such as bound Go methods are not called.
+func templateData(ctx context.Context, v cl.Value) (any, cl.Value) {
+	switch v := v.(type) {
+	case cl.NullValue:
+		return nil, nil
+	case cl.BoolValue:
+		return v.Bool, nil
+	case cl.StringValue:
+		return v.Text, nil
+	case cl.NumValue:
+		if v.Num.IsInt() && v.Num.Num().IsInt64() {
+			return v.Num.Num().Int64(), nil
+		}
+		return cl.Show(ctx, v), nil
+	case cl.SliceValue:
+		result := []any{}
+		for _, e := range v {
+			x, err := templateData(ctx, e)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, x)
+		}
+		return result, nil
+	case cl.MapValue:
+		result := map[string]any{}
+		for k, e := range v {
+			x, err := templateData(ctx, e)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = x
+		}
+		return result, nil
+	default:
+		if cl.IsError(v) {
+			return nil, v
+		}
+		return cl.Show(ctx, v), nil
+	}
+}
@@ -0,0 +1,44 @@
+package complang
+
+import (
+	"context"
+	"fmt"
+)
+
+func (x StringValue) respond(ctx context.Context, message string) (Value, bool) {
+	switch message {
+	case "+":
+		return builtin(message, "y", func(ctx context.Context, arg Value) Value {
+			switch y := Force(ctx, arg).(type) {
+			case StringValue:
+				return StringValue{x.Text + y.Text}
+			default:
+				if IsError(y) {
+					return y
+				}
+				return Error{
+					ErrorMessage: fmt.Sprintf("expected a string, got %s", describeValue(ctx, y)),
+					Code:         TypeErrorCode,
+				}
+			}
+		}), true
+	case "==", "!=":
+		return equality(message, func(ctx context.Context, arg Value) bool {
+			y, ok := arg.(StringValue)
+			return ok && x.Text == y.Text
+		}), true
+	default:
+		return nil, false
+	}
+}
+
+// equality implements the == and != messages given a test for equality with the forced argument.
+func equality(message string, equal func(context.Context, Value) bool) Value {
+	return builtin(message, "y", func(ctx context.Context, arg Value) Value {
+		eq := equal(ctx, Force(ctx, arg))
+		if message == "!=" {
+			eq = !eq
+		}
+		return BoolValue{eq}
+	})
+}
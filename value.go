@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"strings"
 )
 
 type Value interface {
@@ -12,7 +13,10 @@ type Value interface {
 }
 
 // :show -- objects respond to :show with a string value to customize how they will be displayed.
-type ShowMessage struct{}
+type ShowMessage struct {
+	// Full asks for an untruncated rendering, for example to display the value in a pager.
+	Full bool
+}
 
 func (sm ShowMessage) Message(ctx context.Context, v Value) Value {
 	switch v.(type) {
@@ -56,65 +60,49 @@ func (cr CompleteRequest) Message(ctx context.Context, v Value) Value {
 	}
 }
 
-type Error struct {
-	ErrorMessage string
-}
-
-func (x Error) Message(_ context.Context, v Value) Value {
-	switch v.(type) {
-	case ShowMessage:
-		return StringValue{fmt.Sprintf("ERROR: %s", x.ErrorMessage)}
-	case RunMessage:
-		return x
-	default:
-		// Errors are self-evaluating to float out of expressions.
-		return x
-	}
-}
-
-func DoNotUnderstandError(ctx context.Context, obj Value, message Value) Value {
-	return &doesNotUnderstandError{obj: obj, message: message}
-}
-
-type doesNotUnderstandError struct {
-	obj     Value
-	message Value
-}
-
-var _ Value = (*doesNotUnderstandError)(nil)
-
-func (dne *doesNotUnderstandError) Message(ctx context.Context, msg Value) Value {
-	e := &Error{fmt.Sprintf("object %s does not understand %s",
-		Show(ctx, dne.obj),
-		Show(ctx, dne.message))}
-	return e.Message(ctx, msg)
-}
-
 type StringValue struct {
 	Text string
 }
 
 func (x StringValue) Message(ctx context.Context, v Value) Value {
-	switch v.(type) {
+	switch v := v.(type) {
 	case ShowMessage:
 		return StringValue{pretty(x.Text, -1, -1)}
 	case RunMessage:
 		return x
+	case StringValue:
+		if r, ok := x.respond(ctx, v.Text); ok {
+			return r
+		}
+		return DoNotUnderstandError(ctx, x, v)
 	default:
 		return DoNotUnderstandError(ctx, x, v)
 	}
 }
 
+// NumValue is an exact rational number.
 type NumValue struct {
-	Num *big.Float
+	Num *big.Rat
 }
 
 func (x NumValue) Message(ctx context.Context, v Value) Value {
-	switch v.(type) {
+	switch v := v.(type) {
 	case ShowMessage:
-		return StringValue{pretty(x.Num.String(), -1, -1)}
+		return StringValue{FormatNum(x.Num)}
 	case RunMessage:
 		return x
+	case StringValue:
+		if r, ok := x.respond(ctx, v.Text); ok {
+			return r
+		}
+		return DoNotUnderstandError(ctx, x, v)
+	case CompleteRequest:
+		for _, m := range numMessages {
+			if !v.Receiver(v.Query, m) {
+				break
+			}
+		}
+		return NullValue{}
 	default:
 		return DoNotUnderstandError(ctx, x, v)
 	}
@@ -125,12 +113,37 @@ func Show(ctx context.Context, v Value) string {
 	case StringValue:
 		return x.Text
 	default:
-		return Show(ctx, Error{"object does not respond to :show properly"})
+		return Show(ctx, Error{ErrorMessage: "object does not respond to :show properly"})
+	}
+}
+
+// ShowFull is like Show but asks the value not to truncate its rendering.
+func ShowFull(ctx context.Context, v Value) string {
+	switch x := v.Message(ctx, ShowMessage{Full: true}).(type) {
+	case StringValue:
+		return x.Text
+	default:
+		return Show(ctx, Error{ErrorMessage: "object does not respond to :show properly"})
 	}
 }
 
+// Run performs the side-effects that v describes, returning the final value. Closures are called in a
+// loop, so that calls in tail position do not grow the stack.
 func Run(ctx context.Context, v Value) Value {
-	return v.Message(ctx, RunMessage{})
+	for {
+		if next, ok := step(ctx, v); ok {
+			v = next
+			continue
+		}
+		c, ok := v.(Closure)
+		if !ok {
+			return v.Message(ctx, RunMessage{})
+		}
+		if len(c.Params) > 0 {
+			return c
+		}
+		v = c.call(ctx)
+	}
 }
 
 func Complete(ctx context.Context, v Value, req CompleteRequest) {
@@ -155,7 +168,7 @@ type BoolValue struct {
 }
 
 func (x BoolValue) Message(ctx context.Context, v Value) Value {
-	switch v.(type) {
+	switch v := v.(type) {
 	case ShowMessage:
 		if x.Bool {
 			return StringValue{"true"}
@@ -163,6 +176,18 @@ func (x BoolValue) Message(ctx context.Context, v Value) Value {
 		return StringValue{"false"}
 	case RunMessage:
 		return x
+	case StringValue:
+		if r, ok := x.respond(ctx, v.Text); ok {
+			return r
+		}
+		return DoNotUnderstandError(ctx, x, v)
+	case CompleteRequest:
+		for _, m := range boolMessages {
+			if !v.Receiver(v.Query, m) {
+				break
+			}
+		}
+		return NullValue{}
 	default:
 		return DoNotUnderstandError(ctx, x, v)
 	}
@@ -173,15 +198,20 @@ type SliceValue []Value
 func (x SliceValue) Message(ctx context.Context, v Value) Value {
 	switch v := v.(type) {
 	case ShowMessage:
+		if v.Full {
+			return StringValue{pretty(x, -1, -1)}
+		}
 		return StringValue{pretty(x, 32, 128)}
 	case NumValue:
 		if v.Num.IsInt() {
-			i, _ := v.Num.Int64()
-			j := int(i)
-			if j < 0 || j >= len(x) {
-				return Error{fmt.Sprintf("Index out of range: %d", j)}
+			i := v.Num.Num()
+			if !i.IsInt64() || i.Int64() < 0 || i.Int64() >= int64(len(x)) {
+				return Error{
+					ErrorMessage: fmt.Sprintf("Index out of range: %s", i),
+					Code:         IndexOutOfRangeCode,
+				}
 			}
-			return x[int(i)]
+			return x[int(i.Int64())]
 		}
 		return DoNotUnderstandError(ctx, x, v)
 	case RunMessage:
@@ -196,6 +226,9 @@ type MapValue map[string]Value
 func (x MapValue) Message(ctx context.Context, v Value) Value {
 	switch v := v.(type) {
 	case ShowMessage:
+		if v.Full {
+			return StringValue{pretty(x, -1, -1)}
+		}
 		return StringValue{pretty(x, 32, 128)}
 	case RunMessage:
 		return x
@@ -222,55 +255,115 @@ type Closure struct {
 	Call    func(context.Context, Env) Value
 	PostRun []Value
 	IsPure  bool
+
+	// Name identifies closures implemented in Go, such as bound methods, and functions defined with
+	// def in :show and :plan output.
+	Name string
+
+	// Path is the expression through which the evaluator reached a closure implemented in Go, such
+	// as `$db Users Delete`, as the source wrote it.
+	Path string
+
+	// Doc documents functions defined with def.
+	Doc string
+
+	// Args collects the arguments applied so far, in order.
+	Args []Value
+
+	// Effect declares the side-effects performed by Call. It is ignored for transparent closures,
+	// and for pure closures unless it is stronger than ReadEffect.
+	Effect Effect
+
+	// Transparent marks closures whose Call only evaluates complang code, such as lambda blocks.
+	// Calling them has no side-effects of its own as effects only happen when their result is run,
+	// so :plan expands them instead of reporting an opaque call.
+	Transparent bool
+
+	// Source is the text that the code of a transparent closure was parsed from. Calls evaluate with
+	// it as the source set by WithSource.
+	Source string
 }
 
+// Message applies the closure to msg, unless msg is one of the messages of the interpreter such as
+// ShowMessage. Application follows these rules:
+//
+//   - While parameters remain, each message is an argument that binds the next parameter. The result
+//     is a copy of the closure with one parameter less, so IsPure, PostRun and the other fields are
+//     preserved through partial application.
+//   - Once all parameters are bound, a pure closure is called and msg is sent to its result.
+//   - A saturated closure that is not pure records msg in PostRun, to be sent to its result when it is
+//     run, so that sending messages never performs side-effects.
+//
+// Messages to a saturated closure thus go to its result rather than failing as extra arguments. Apply
+// checks the number of arguments against the arity instead.
 func (c Closure) Message(ctx context.Context, msg Value) Value {
 	switch msg := msg.(type) {
 	case ShowMessage:
 		return StringValue{c.show()}
 	case RunMessage:
-		return c.run(ctx)
+		return Run(ctx, c)
+	case PlanRequest:
+		c.plan(ctx, msg)
+		return NullValue{}
 	case Error:
 		return msg
 	default:
 		switch {
-		case len(c.Params) == 0 && c.IsPure:
-			return c.run(ctx).Message(ctx, msg)
-		case len(c.Params) == 0 && !c.IsPure:
-			return Closure{
-				Env:     c.Env,
-				Call:    c.Call,
-				PostRun: append(c.PostRun, msg),
+		case len(c.Params) > 0:
+			applied := c
+			applied.Env = &extendedEnv{
+				Env:    c.Env,
+				symbol: c.Params[0],
+				value:  msg,
 			}
-
+			applied.Params = c.Params[1:]
+			applied.Args = append(c.Args[:len(c.Args):len(c.Args)], msg)
+			return applied
+		case c.IsPure:
+			return Run(ctx, c).Message(ctx, msg)
 		default:
-			return Closure{
-				Env: &extendedEnv{
-					Env:    c.Env,
-					symbol: c.Params[0],
-					value:  msg,
-				},
-				Params: c.Params[1:],
-				Call:   c.Call,
-			}
+			deferred := c
+			deferred.PostRun = append(c.PostRun[:len(c.PostRun):len(c.PostRun)], msg)
+			return deferred
 		}
 	}
 }
 
-func (c Closure) run(ctx context.Context) Value {
-	if len(c.Params) == 0 {
-		v := c.Call(ctx, c.Env)
-		for _, msg := range c.PostRun {
-			v = v.Message(ctx, msg)
-		}
-		return Run(ctx, v)
+// Arity is the number of arguments the closure takes before it is called.
+func (c Closure) Arity() int {
+	return len(c.Params)
+}
+
+// call calls a closure whose parameters are all bound, one level deeper in the stack of calls, and
+// sends the PostRun messages to the result. Side-effects of the result are left to the caller to run.
+func (c Closure) call(ctx context.Context) Value {
+	if err := checkEffectLimit(ctx, c); err != nil {
+		observeRun(ctx, c, err)
+		return err
+	}
+	inner, err := enterCall(ctx, c)
+	if err != nil {
+		return err
 	}
-	return c
+	v := c.Call(inner, c.Env)
+	observeRun(ctx, c, v)
+	for _, msg := range c.PostRun {
+		v = v.Message(ctx, msg)
+	}
+	return v
+}
+
+// Signature describes how to call the closure, as its name followed by the remaining parameters.
+func (c Closure) Signature() string {
+	return strings.Join(append([]string{c.Name}, c.Params...), " ")
 }
 
 func (c Closure) show() string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "<Closure")
+	if c.Name != "" {
+		fmt.Fprintf(&buf, " %s", c.Name)
+	}
 	for i, p := range c.Params {
 		if i > 0 {
 			fmt.Fprintf(&buf, ",")
@@ -283,6 +376,24 @@ func (c Closure) show() string {
 	return buf.String()
 }
 
+// Function is implemented by values that take a fixed number of arguments, such as closures.
+type Function interface {
+	Value
+	Arity() int
+}
+
+// Apply sends each of args to f in turn. Unlike sending them one by one, it is an error with
+// ArityCode to pass a Function more arguments than it takes.
+func Apply(ctx context.Context, f Value, args []Value) Value {
+	if fn, ok := Undefer(f).(Function); ok && len(args) > fn.Arity() {
+		return ArityError(ctx, fn, len(args))
+	}
+	for _, arg := range args {
+		f = f.Message(ctx, arg)
+	}
+	return f
+}
+
 func OverloadedValue(primaryReceiver, fallbackReceiver Value) Value {
 	return &overloadedValue{primaryReceiver, fallbackReceiver}
 }